@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// aggregateInfo is the answer for the IPv6 prefix an address falls in,
+// under ?aggregate=N
+type aggregateInfo struct {
+	Prefix string `json:"prefix"`
+	// Country is the one covering most of the prefix, or null when most of
+	// it is a gap in the data
+	Country *string `json:"country"`
+	// Share is the fraction of the prefix Country covers, 1 when the whole
+	// prefix resolves to it
+	Share float64 `json:"share"`
+}
+
+// parseAggregate reads ?aggregate=N, the IPv6 prefix length to aggregate
+// lookups to, responding with a 400 if it is invalid. It returns 0 when the
+// parameter is absent.
+func (a *app) parseAggregate(c *gin.Context) (bits int, ok bool) {
+	raw := c.Query("aggregate")
+	if raw == "" {
+		return 0, true
+	}
+	minBits := 128 - a.cfg.MaxIpv6SpanBits
+	bits, err := strconv.Atoi(raw)
+	if err != nil || bits < max(minBits, 1) || bits > 128 {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("aggregate must be a prefix length between %d and 128", max(minBits, 1)))
+		return 0, false
+	}
+	return bits, true
+}
+
+// aggregateResults fills in the aggregate of each IPv6 input address over
+// its /bits prefix, breaking the prefix down as /getCidrInfo does. inputs
+// are the addresses results were looked up from, in the same order. IPv4
+// addresses, including IPv4-mapped ones, are left without one, while IPv6
+// addresses get one even when they matched no range themselves.
+func (a *app) aggregateResults(c *gin.Context, data *dataset, inputs []string, results []ApiResponse, bits int) {
+	if bits == 0 {
+		return
+	}
+	for i, raw := range inputs {
+		ipAddr := parseIpAddress(raw)
+		if ipAddr == nil || ipAddr.IpAddr == nil || results[i].Error == codeFamilyDisabled {
+			continue
+		}
+		addr, err := netip.ParseAddr(*ipAddr.IpAddr)
+		if err != nil || !addr.Is6() || addr.Is4In6() {
+			continue
+		}
+		prefix := netip.PrefixFrom(addr.WithZone(""), bits).Masked()
+		res, _, err := a.cidrInfo(c.Request.Context(), data, prefix.String(), false, 0)
+		if err != nil || requestDone(c) {
+			return
+		}
+		results[i].Aggregate = aggregateSegments(res)
+	}
+}
+
+// aggregateSegments picks the country covering the most addresses of a
+// prefix's breakdown, counting gaps as a null country
+func aggregateSegments(res cidrResult) *aggregateInfo {
+	covered := map[string]*big.Int{}
+	var order []string
+	for _, seg := range res.Segments {
+		country := ""
+		if seg.Country != nil {
+			country = *seg.Country
+		}
+		size := new(big.Int).Sub(ipToNum(net.ParseIP(seg.End)), ipToNum(net.ParseIP(seg.Start)))
+		size.Add(size, one)
+		if covered[country] == nil {
+			covered[country] = new(big.Int)
+			order = append(order, country)
+		}
+		covered[country].Add(covered[country], size)
+	}
+
+	info := &aggregateInfo{Prefix: res.Cidr}
+	var best *big.Int
+	for _, country := range order {
+		if best != nil && covered[country].Cmp(best) <= 0 {
+			continue
+		}
+		best = covered[country]
+		info.Country = nil
+		if country != "" {
+			info.Country = &country
+		}
+	}
+	prefix := netip.MustParsePrefix(res.Cidr)
+	total := new(big.Int).Lsh(one, uint(prefix.Addr().BitLen()-prefix.Bits()))
+	info.Share, _ = new(big.Rat).SetFrac(best, total).Float64()
+	return info
+}
@@ -0,0 +1,17 @@
+package main
+
+import "net"
+
+var (
+	anonymizeMaskV4 = net.CIDRMask(24, 32)
+	anonymizeMaskV6 = net.CIDRMask(48, 128)
+)
+
+// anonymizeIP zeroes the last octet of an IPv4 address and the last 80 bits
+// of an IPv6 one, for logging
+func anonymizeIP(addr net.IP) net.IP {
+	if v4 := addr.To4(); v4 != nil {
+		return v4.Mask(anonymizeMaskV4)
+	}
+	return addr.Mask(anonymizeMaskV6)
+}
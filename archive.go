@@ -0,0 +1,266 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// updateFromArchive is the update step when DATA_ARCHIVE_URL is set: the
+// whole dataset is fetched as one .zip or .tar.gz and the members matching
+// the data files are extracted into the data directory. Like the per-file
+// update it only runs when a file is missing or auto-update is enabled, and
+// each member is validated before it replaces the local copy. A data file
+// missing from the archive fails.
+func updateFromArchive(cfg *Config) (updateResult, error) {
+	var res updateResult
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return res, fmt.Errorf("creating data directory: %w", err)
+	}
+
+	var wanted []*fileInfo
+	missing := false
+	for i := range cfg.Files {
+		if cfg.familyEnabled(cfg.Files[i].IpV6) {
+			wanted = append(wanted, &cfg.Files[i])
+			if _, err := os.Stat(filepath.Join(cfg.DataDir, cfg.Files[i].LocalName)); err != nil {
+				missing = true
+			}
+		}
+	}
+	if !missing && !cfg.AutoUpdate && !cfg.AutoUpdateDryRun {
+		for _, fi := range wanted {
+			res.Files = append(res.Files, fileUpdateStatus{File: fi.LocalName, Status: fileUnchanged.String()})
+		}
+		sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].File < res.Files[j].File })
+		return res, nil
+	}
+
+	archivePath, err := downloadArchive(cfg.outbound, cfg.DataArchiveURL, cfg.DataDir)
+	if err != nil {
+		return res, err
+	}
+	defer os.Remove(archivePath)
+
+	outcomes := map[*fileInfo]fileUpdate{}
+	fileErrs := map[*fileInfo]error{}
+	err = walkArchive(archivePath, func(name string, r io.Reader) error {
+		fi := archiveMember(wanted, name)
+		if fi == nil {
+			return nil
+		}
+		if _, seen := outcomes[fi]; seen {
+			return fmt.Errorf("archive has more than one member for %s", fi.RemotePath)
+		}
+		outcomes[fi], fileErrs[fi] = installArchiveMember(cfg, fi, r)
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("reading archive: %w", err)
+	}
+
+	var errs []error
+	for _, fi := range wanted {
+		outcome, seen := outcomes[fi]
+		err := fileErrs[fi]
+		if !seen {
+			outcome, err = fileFailed, fmt.Errorf("archive has no member %s", fi.RemotePath)
+		}
+		st := fileUpdateStatus{File: fi.LocalName, Status: outcome.String()}
+		if err != nil {
+			st.Error = err.Error()
+		}
+		res.Files = append(res.Files, st)
+		switch {
+		case outcome == fileRejected:
+			// The previous file is kept, so this isn't fatal
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", fi.LocalName, err))
+		case outcome == fileDownloaded:
+			res.Updated = append(res.Updated, fi.LocalName)
+		case outcome == fileChangePending:
+			res.Pending = append(res.Pending, fi.LocalName)
+		}
+	}
+	sort.Strings(res.Updated)
+	sort.Strings(res.Pending)
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].File < res.Files[j].File })
+	return res, errors.Join(errs...)
+}
+
+// downloadArchive fetches url into a temporary file in dir and returns its
+// path
+func downloadArchive(client *http.Client, url, dir string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("downloading archive: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad archive download status: %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/html" {
+		return "", errors.New("archive download is an HTML page")
+	}
+
+	tmp, err := os.CreateTemp(dir, "archive.*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary file: %w", err)
+	}
+	_, err = io.Copy(tmp, resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("writing archive: %w", err)
+	}
+	return tmp.Name(), nil
+}
+
+// walkArchive calls fn with the name and contents of each regular file in
+// the .zip or .tar.gz at path, telling them apart by their magic bytes
+func walkArchive(path string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic, err := bufio.NewReader(f).Peek(4)
+	if err != nil {
+		return errors.New("archive is too short")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return err
+		}
+		for _, member := range zr.File {
+			if !member.Mode().IsRegular() {
+				continue
+			}
+			rc, err := member.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(member.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := fn(hdr.Name, tr); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("archive is neither a .zip nor a .tar.gz")
+	}
+}
+
+// archiveMember returns the data file an archive member holds: one whose
+// remote path is the member name, or its tail after a top-level directory
+// such as "ip-location-db-main/"
+func archiveMember(wanted []*fileInfo, name string) *fileInfo {
+	name = strings.TrimPrefix(filepath.ToSlash(name), "./")
+	for _, fi := range wanted {
+		if name == fi.RemotePath || strings.HasSuffix(name, "/"+fi.RemotePath) {
+			return fi
+		}
+	}
+	return nil
+}
+
+// installArchiveMember writes r next to fi's local file and moves it into
+// place if it differs from the local copy and passes validation, or in
+// dry-run mode only reports the change
+func installArchiveMember(cfg *Config, fi *fileInfo, r io.Reader) (fileUpdate, error) {
+	localPath := filepath.Join(cfg.DataDir, fi.LocalName)
+	_, err := os.Stat(localPath)
+	exists := err == nil
+
+	tmp, err := os.CreateTemp(cfg.DataDir, fi.LocalName+".*.tmp")
+	if err != nil {
+		return fileFailed, fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fileFailed, fmt.Errorf("extracting file: %w", err)
+	}
+
+	if exists {
+		newSha, err1 := gitBlobSha(tmp.Name())
+		localSha, err2 := gitBlobSha(localPath)
+		if err1 == nil && err2 == nil && newSha == localSha {
+			now := time.Now()
+			if err := os.Chtimes(localPath, now, now); err != nil {
+				slog.Warn("couldn't mark data file as confirmed current", "file", fi.LocalName, "err", err)
+			}
+			return fileUnchanged, nil
+		}
+		if cfg.AutoUpdateDryRun {
+			slog.Info("dry run: would extract changed data file", "file", fi.LocalName, "sha", newSha)
+			return fileChangePending, nil
+		}
+	}
+
+	if err := validateCsvDownload(tmp.Name(), githubContent{}, cfg.csvFormat(fi)); err != nil {
+		if exists {
+			slog.Warn("rejected data file from archive, keeping previous file", "file", fi.LocalName, "reason", err)
+			return fileRejected, err
+		}
+		return fileFailed, err
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return fileFailed, fmt.Errorf("replacing local file: %w", err)
+	}
+	slog.Info("updated data file from archive", "file", fi.LocalName)
+	return fileDownloaded, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// attribution is the licensing metadata of one source
+type attribution struct {
+	Source      string `json:"source"`
+	License     string `json:"license,omitempty"`
+	Attribution string `json:"attribution,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+type attributionResponse struct {
+	Sources []attribution `json:"sources"`
+}
+
+// defaultAttributions covers the upstream ip-location-db sources, which
+// SOURCE_LICENSE and SOURCE_ATTRIBUTION override
+var defaultAttributions = map[string]attribution{
+	"geo-whois-asn-country": {License: "CC0-1.0", Attribution: "IP geolocation data by sapics/ip-location-db", URL: "https://github.com/sapics/ip-location-db"},
+	"geo-asn-country":       {License: "CC0-1.0", Attribution: "IP geolocation data by sapics/ip-location-db", URL: "https://github.com/sapics/ip-location-db"},
+}
+
+// sourceAttribution returns the metadata of source, the configured license
+// and text taking precedence over the defaults
+func (cfg *Config) sourceAttribution(source string) attribution {
+	attr := defaultAttributions[source]
+	attr.Source = source
+	if license, ok := cfg.SourceLicense[source]; ok {
+		attr.License = license
+	}
+	if text, ok := cfg.SourceAttribution[source]; ok {
+		attr.Attribution = text
+	}
+	return attr
+}
+
+// attributions lists the metadata of every source the served data was
+// loaded from, in file order
+func (a *app) attributions() []attribution {
+	attrs := []attribution{}
+	for _, source := range loadedSources(a.statuses()) {
+		attrs = append(attrs, a.cfg.sourceAttribution(source))
+	}
+	return attrs
+}
+
+// attribution serves the licensing metadata of the loaded sources, for
+// downstream users to credit the data as its licenses require
+func (a *app) attribution(c *gin.Context) {
+	c.JSON(http.StatusOK, attributionResponse{Sources: a.attributions()})
+}
+
+// attributionHeader sets X-Data-Attribution on lookups to the attribution
+// texts of the loaded sources, with ATTRIBUTION_HEADER
+func (a *app) attributionHeader(c *gin.Context) {
+	var texts []string
+	for _, attr := range a.attributions() {
+		if attr.Attribution != "" && !slices.Contains(texts, attr.Attribution) {
+			texts = append(texts, attr.Attribution)
+		}
+	}
+	if len(texts) > 0 {
+		c.Header("X-Data-Attribution", strings.Join(texts, "; "))
+	}
+	c.Next()
+}
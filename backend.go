@@ -0,0 +1,36 @@
+package main
+
+// dataSource is where the served ranges come from, selected by DATA_BACKEND
+type dataSource interface {
+	// Update refreshes the backing data, returning what changed. Sources
+	// that are maintained externally do nothing.
+	Update(cfg *Config) (updateResult, error)
+	// Load reads every range, sorted, along with per-file load status.
+	// Duplicates are left in, for loadFromDisk to compare the sources.
+	Load(cfg *Config) ([]IpAddressRange, []fileStatus, error)
+}
+
+func newDataSource(cfg *Config) dataSource {
+	switch cfg.DataBackend {
+	case "sqlite":
+		return sqliteSource{}
+	case "rir":
+		return delegatedSource{}
+	}
+	return csvSource{}
+}
+
+// csvSource serves the sapics CSVs, downloaded into the data directory one
+// by one or, with DATA_ARCHIVE_URL, extracted from a single archive
+type csvSource struct{}
+
+func (csvSource) Update(cfg *Config) (updateResult, error) {
+	if cfg.DataArchiveURL != "" {
+		return updateFromArchive(cfg)
+	}
+	return updateCsvFiles(cfg)
+}
+
+func (csvSource) Load(cfg *Config) ([]IpAddressRange, []fileStatus, error) {
+	return loadCsv(cfg)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wantsStream reports whether the client asked for newline-delimited JSON
+func wantsStream(c *gin.Context) bool {
+	return c.Query("stream") == "1" || strings.Contains(c.GetHeader("Accept"), "application/x-ndjson")
+}
+
+// countryRecordsType is the media type of the binary batch response: one
+// countryRecordSize-byte record per input, in input order
+const countryRecordsType = "application/x-ipgeo-countries"
+
+// countryRecordSize is a match flag byte, 1 when the input resolved to a
+// country and 0 otherwise, followed by the two-letter country code, or two
+// zero bytes without a match
+const countryRecordSize = 3
+
+// wantsCountryRecords reports whether the client asked for the binary
+// batch response
+func wantsCountryRecords(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), countryRecordsType)
+}
+
+// countryRecords encodes results as the binary batch response
+func countryRecords(results []ApiResponse) []byte {
+	buf := make([]byte, countryRecordSize*len(results))
+	for i, resp := range results {
+		if resp.Ok && !resp.Reserved && resp.Country != nil && len(*resp.Country) == 2 {
+			rec := buf[i*countryRecordSize:]
+			rec[0] = 1
+			copy(rec[1:3], *resp.Country)
+		}
+	}
+	return buf
+}
+
+// groupedResponse buckets batch inputs by the country they resolved to
+type groupedResponse struct {
+	Ok        bool                `json:"ok"`
+	Countries map[string][]string `json:"countries"`
+	// Unmatched holds inputs that were invalid, reserved or not found
+	Unmatched []string `json:"unmatched"`
+}
+
+func groupByCountry(addrs []string, results []ApiResponse) groupedResponse {
+	grouped := groupedResponse{Ok: true, Countries: map[string][]string{}, Unmatched: []string{}}
+	for i, resp := range results {
+		if resp.Ok && !resp.Reserved && resp.Country != nil {
+			grouped.Countries[*resp.Country] = append(grouped.Countries[*resp.Country], addrs[i])
+		} else {
+			grouped.Unmatched = append(grouped.Unmatched, addrs[i])
+		}
+	}
+	return grouped
+}
+
+// batchMeta tallies the results of a batch for ?meta=1, which reports them
+// in X-Batch-* headers so that the body keeps its shape
+type batchMeta struct {
+	start                               time.Time
+	processed, matched, missed, invalid int
+}
+
+// batchMetaHeaders are the headers set by batchMeta, in order
+var batchMetaHeaders = []string{"X-Batch-Processed", "X-Batch-Matched", "X-Batch-Missed", "X-Batch-Invalid", "X-Batch-Elapsed-Ms"}
+
+// add counts resp as matched when it resolved to a country, invalid when
+// the input wasn't an address or a resolvable hostname, and missed
+// otherwise: not found, reserved or of a disabled family
+func (m *batchMeta) add(resp ApiResponse) {
+	m.processed++
+	switch {
+	case resp.Ok && !resp.Reserved && resp.Country != nil:
+		m.matched++
+	case resp.Error == codeInvalidIP || resp.Error == codeUnresolved:
+		m.invalid++
+	default:
+		m.missed++
+	}
+}
+
+// write sets the tallies on h, or nothing without ?meta=1
+func (m *batchMeta) write(c *gin.Context, h http.Header) {
+	if c.Query("meta") != "1" {
+		return
+	}
+	values := []int{m.processed, m.matched, m.missed, m.invalid, int(time.Since(m.start).Milliseconds())}
+	for i, name := range batchMetaHeaders {
+		h.Set(name, strconv.Itoa(values[i]))
+	}
+}
+
+// getIpInfoBatch looks up a JSON array of addresses, which may include
+// hostnames when BATCH_HOSTNAMES is enabled. By default it responds
+// with an array in input order; in streaming mode each result is written
+// as its own line and flushed as soon as it is ready. With ?group=country
+// the inputs are bucketed by country instead, which needs every result, so
+// it takes precedence over streaming, as does asking for the binary
+// countryRecords with an Accept header. ?meta=1 adds the batchMeta
+// headers, sent as trailers when streaming.
+func (a *app) getIpInfoBatch(c *gin.Context) {
+	meta := &batchMeta{start: time.Now()}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, a.cfg.MaxUploadBytes)
+
+	var addrs []string
+	if err := json.NewDecoder(c.Request.Body).Decode(&addrs); err != nil {
+		if requestDone(c) {
+			respondDone(c)
+			return
+		}
+		respondBodyError(c, err, "body must be a JSON array of IP addresses")
+		return
+	}
+	if len(addrs) > a.cfg.MaxBatch {
+		respondError(c, http.StatusBadRequest, codeTooMany, fmt.Sprintf("at most %d addresses per request", a.cfg.MaxBatch))
+		return
+	}
+
+	var hosts map[string]netip.Addr
+	if a.cfg.BatchHostnames {
+		names := batchHostnames(addrs)
+		if len(names) > a.cfg.MaxBatchHostnames {
+			respondError(c, http.StatusBadRequest, codeTooMany, fmt.Sprintf("at most %d hostnames per request", a.cfg.MaxBatchHostnames))
+			return
+		}
+		hosts = a.resolveHostnames(c.Request.Context(), names)
+		if requestDone(c) {
+			respondDone(c)
+			return
+		}
+	}
+
+	data := a.current()
+	if !wantsStream(c) || c.Query("group") == "country" || wantsCountryRecords(c) {
+		results := make([]ApiResponse, len(addrs))
+		for i, raw := range addrs {
+			if requestDone(c) {
+				respondDone(c)
+				return
+			}
+			results[i] = a.resolveBatchItem(c, data, raw, hosts)
+			meta.add(results[i])
+		}
+		meta.write(c, c.Writer.Header())
+		if c.Query("group") == "country" {
+			renderJSON(c, http.StatusOK, groupByCountry(addrs, results))
+			return
+		}
+		if wantsCountryRecords(c) {
+			c.Data(http.StatusOK, countryRecordsType, countryRecords(results))
+			return
+		}
+		renderJSON(c, http.StatusOK, results)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	if c.Query("meta") == "1" {
+		c.Header("Trailer", strings.Join(batchMetaHeaders, ", "))
+	}
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	for _, raw := range addrs {
+		if requestDone(c) {
+			respondDone(c)
+			return
+		}
+		resp := a.resolveBatchItem(c, data, raw, hosts)
+		meta.add(resp)
+		enc.Encode(shape(c, resp))
+		c.Writer.Flush()
+	}
+	meta.write(c, c.Writer.Header())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stopEnricher ends the request on its stopAt-th call, by canceling it or,
+// with cancel nil, by blocking until its deadline passes. It counts how
+// many lookups it saw.
+type stopEnricher struct {
+	stopAt int
+	cancel context.CancelFunc
+	calls  int
+}
+
+func (e *stopEnricher) Enrich(ctx context.Context, ip netip.Addr, resp *ApiResponse) error {
+	e.calls++
+	if e.calls == e.stopAt {
+		if e.cancel != nil {
+			e.cancel()
+		} else {
+			<-ctx.Done()
+		}
+	}
+	return nil
+}
+
+func TestGetIpInfoBatchStopsWhenDone(t *testing.T) {
+	body := `["1.0.0.1","1.0.0.2","1.0.0.3","1.0.0.4","1.0.0.5","1.0.0.6","1.0.0.7","1.0.0.8"]`
+	tests := []struct {
+		name     string
+		timeout  bool
+		stream   bool
+		status   int
+		code     string
+		wantSeen int // results written before the error, when streaming
+	}{
+		{name: "canceled", status: statusClientClosedRequest, code: codeCanceled},
+		{name: "timed out", timeout: true, status: http.StatusGatewayTimeout, code: codeTimeout},
+		{name: "canceled while streaming", stream: true, status: http.StatusOK, code: codeCanceled, wantSeen: 3},
+		{name: "timed out while streaming", timeout: true, stream: true, status: http.StatusOK, code: codeTimeout, wantSeen: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, "16777216-16777471:AU")
+			var ctx context.Context
+			var cancel context.CancelFunc
+			if tt.timeout {
+				ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+			} else {
+				ctx, cancel = context.WithCancel(context.Background())
+			}
+			defer cancel()
+			stop := &stopEnricher{stopAt: 3}
+			if !tt.timeout {
+				stop.cancel = cancel
+			}
+			a.enrichers = []namedEnricher{{"stop", stop}}
+
+			target := "/getIpInfoBatch"
+			if tt.stream {
+				target += "?stream=1"
+			}
+			req := httptest.NewRequest("POST", target, strings.NewReader(body)).WithContext(ctx)
+			w := serveTest(a.getIpInfoBatch, req)
+
+			if stop.calls != 3 {
+				t.Errorf("resolved %d addresses, want to stop after the 3rd of 8", stop.calls)
+			}
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			lines := 0
+			var last errorResponse
+			for sc := bufio.NewScanner(w.Body); sc.Scan(); lines++ {
+				last = errorResponse{}
+				json.Unmarshal(sc.Bytes(), &last)
+			}
+			if last.Error.Code != tt.code || lines != tt.wantSeen+1 {
+				t.Errorf("%d lines ending in %+v, want %d results and then %s", lines, last, tt.wantSeen, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	candidateDefaultSamples   = 1000
+	candidateMaxSamples       = 100000
+	candidateDefaultTolerance = 0.001
+	candidateMaxExamples      = 20
+)
+
+// candidate is a dataset loaded next to the live one for comparison before
+// it replaces it
+type candidate struct {
+	data   *dataset
+	report candidateReport
+}
+
+// candidateRequest is the body of POST /admin/candidate. Settings are keyed
+// like env vars and override the live config for the candidate only.
+type candidateRequest struct {
+	Settings  map[string]string `json:"settings"`
+	Samples   int               `json:"samples"`
+	Tolerance *float64          `json:"tolerance"`
+	// Update runs the candidate's update step first, downloading changed
+	// files into its DATA_DIR
+	Update bool `json:"update"`
+}
+
+type candidateDisagreement struct {
+	Ip        string `json:"ip"`
+	Live      string `json:"live"`
+	Candidate string `json:"candidate"`
+}
+
+// candidateReport compares lookups of sample IPs against the live and the
+// candidate data. An empty country in a disagreement means no match.
+type candidateReport struct {
+	Ranges          int                     `json:"ranges"`
+	LiveRanges      int                     `json:"live_ranges"`
+	Settings        map[string]string       `json:"settings,omitempty"`
+	Samples         int                     `json:"samples"`
+	Disagreements   int                     `json:"disagreements"`
+	DisagreeRate    float64                 `json:"disagree_rate"`
+	Tolerance       float64                 `json:"tolerance"`
+	WithinTolerance bool                    `json:"within_tolerance"`
+	Examples        []candidateDisagreement `json:"examples,omitempty"`
+	LoadedAt        time.Time               `json:"loaded_at"`
+}
+
+// compareDatasets looks up samples IPs in both live and cand. The IPs are
+// drawn from random ranges of each side in turn, so that data only one
+// side has is sampled too.
+func compareDatasets(live, cand *dataset, samples int, tolerance float64) (candidateReport, error) {
+	report := candidateReport{
+		Ranges:     len(cand.arr),
+		LiveRanges: len(live.arr),
+		Tolerance:  tolerance,
+	}
+	for i := 0; i < samples; i++ {
+		from := live.arr
+		if i%2 == 1 || len(from) == 0 {
+			from = cand.arr
+		}
+		if len(from) == 0 {
+			break
+		}
+		ipNum, err := sampleRange(from)
+		if err != nil {
+			return report, fmt.Errorf("sampling ranges: %w", err)
+		}
+		report.Samples++
+
+		liveCountry, candCountry := rangeCountry(live.arr, ipNum), rangeCountry(cand.arr, ipNum)
+		if liveCountry == candCountry {
+			continue
+		}
+		report.Disagreements++
+		if len(report.Examples) < candidateMaxExamples {
+			ip := numToIp(ipNum, ipNum.Cmp(maxIpv4Num) > 0).String()
+			report.Examples = append(report.Examples, candidateDisagreement{ip, liveCountry, candCountry})
+		}
+	}
+	if report.Samples > 0 {
+		report.DisagreeRate = float64(report.Disagreements) / float64(report.Samples)
+	}
+	report.WithinTolerance = report.DisagreeRate <= tolerance
+	return report, nil
+}
+
+// sampleRange returns a random IP number inside a random range of arr,
+// failing for an empty arr or an inverted range
+func sampleRange(arr []IpAddressRange) (*big.Int, error) {
+	if len(arr) == 0 {
+		return nil, errors.New("no ranges to sample")
+	}
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(arr))))
+	if err != nil {
+		return nil, err
+	}
+	r := &arr[i.Int64()]
+	size := rangeSize(r)
+	if size.Sign() <= 0 {
+		return nil, fmt.Errorf("range %s-%s ends before it starts", r.start, r.end)
+	}
+	offset, err := rand.Int(rand.Reader, size)
+	if err != nil {
+		return nil, err
+	}
+	return offset.Add(offset, r.start), nil
+}
+
+func rangeCountry(arr []IpAddressRange, ipNum *big.Int) string {
+	if match := findRange(arr, ipNum); match != nil {
+		return match.country
+	}
+	return ""
+}
+
+// adminLoadCandidate loads a candidate dataset from disk, after updating it
+// if requested, with the live settings plus the requested overrides,
+// compares it with the live data and
+// keeps it for /admin/candidate/swap. It replaces any earlier candidate.
+func (a *app) adminLoadCandidate(c *gin.Context) {
+	var req candidateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if req.Samples == 0 {
+		req.Samples = candidateDefaultSamples
+	}
+	tolerance := candidateDefaultTolerance
+	if req.Tolerance != nil {
+		tolerance = *req.Tolerance
+	}
+	if req.Samples < 0 || req.Samples > candidateMaxSamples || tolerance < 0 || tolerance > 1 {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "samples must be between 1 and 100000 and tolerance between 0 and 1")
+		return
+	}
+
+	cfg, err := loadConfig(a.configPath, req.Settings)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	a.candidateMu.Lock()
+	defer a.candidateMu.Unlock()
+	// Drop the previous candidate first so two don't sit in memory at once
+	a.candidate = nil
+	var data *dataset
+	if req.Update {
+		a.updates.Lock()
+		data, err = loadDataset(cfg, newDataSource(cfg))
+		a.updates.Unlock()
+	} else {
+		data, err = loadFromDisk(cfg, newDataSource(cfg), updateResult{}, time.Now())
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	report, err := compareDatasets(a.current(), data, req.Samples, tolerance)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	report.Settings = req.Settings
+	report.LoadedAt = time.Now()
+	a.candidate = &candidate{data: data, report: report}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "report": report})
+}
+
+// adminCandidateReport shows the staged candidate's comparison report
+func (a *app) adminCandidateReport(c *gin.Context) {
+	a.candidateMu.Lock()
+	defer a.candidateMu.Unlock()
+	if a.candidate == nil {
+		respondError(c, http.StatusNotFound, codeNoCandidate, "no candidate loaded")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "report": a.candidate.report})
+}
+
+// adminSwapCandidate makes the staged candidate the live dataset. Unless
+// ?force=1 is given, it refuses a candidate that disagreed with the live
+// data by more than the tolerance. Later reloads use the candidate's
+// settings.
+func (a *app) adminSwapCandidate(c *gin.Context) {
+	a.candidateMu.Lock()
+	defer a.candidateMu.Unlock()
+	cand := a.candidate
+	if cand == nil {
+		respondError(c, http.StatusNotFound, codeNoCandidate, "no candidate loaded")
+		return
+	}
+	if !cand.report.WithinTolerance && c.Query("force") != "1" {
+		c.JSON(http.StatusConflict, gin.H{
+			"ok":     false,
+			"error":  apiError{codeOutsideTolerance, "candidate disagrees beyond tolerance, pass force=1 to swap anyway"},
+			"report": cand.report,
+		})
+		return
+	}
+
+	// Swap as a reload of its own, so a running reload can't replace the
+	// candidate with data loaded with the old settings. Joining that reload
+	// doesn't swap, so wait for it and try again.
+	for joined := true; joined; {
+		_, joined = a.reloads.do(func() reloadResult {
+			a.data.Store(cand.data)
+			return reloadResult{Ok: true, Ranges: len(cand.data.arr)}
+		})
+	}
+	a.candidate = nil
+	debug.FreeOSMemory()
+	c.JSON(http.StatusOK, gin.H{"ok": true, "ranges": len(cand.data.arr)})
+}
+
+// adminDiscardCandidate drops the staged candidate
+func (a *app) adminDiscardCandidate(c *gin.Context) {
+	a.candidateMu.Lock()
+	a.candidate = nil
+	a.candidateMu.Unlock()
+	debug.FreeOSMemory()
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cityRange is a block from the optional city dataset
+type cityRange struct {
+	start *big.Int
+	end   *big.Int
+	city  string
+	lat   float64
+	lon   float64
+}
+
+// loadCityCsv reads the city CSV at path, laid out as the dbip-city -num
+// files: start_num,end_num,country,state1,state2,city,postcode,latitude,
+// longitude, with any further columns ignored. Rows whose coordinates don't
+// parse or are out of range are skipped.
+func loadCityCsv(path string) []cityRange {
+	arr := []cityRange{}
+	badCoords := 0
+	counts, err := readRangeCsv(path, defaultCsvFormat, 9, func(start, end *big.Int, rec []string) {
+		lat, latErr := strconv.ParseFloat(rec[7], 64)
+		lon, lonErr := strconv.ParseFloat(rec[8], 64)
+		if latErr != nil || lonErr != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			badCoords++
+			return
+		}
+		arr = append(arr, cityRange{start, end, rec[5], lat, lon})
+	})
+	if err != nil {
+		slog.Error("reading city file", "file", path, "err", err)
+	}
+	if skipped := counts.skipped + badCoords; skipped > 0 {
+		slog.Warn("skipped malformed rows", "file", path, "rows", skipped)
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].start.Cmp(arr[j].start) < 0
+	})
+	return arr
+}
+
+// findCityRange returns the city block containing ipNum, or nil
+func findCityRange(arr []cityRange, ipNum *big.Int) *cityRange {
+	idx := sort.Search(len(arr), func(i int) bool {
+		return arr[i].start.Cmp(ipNum) > 0
+	})
+	if idx > 0 && arr[idx-1].end.Cmp(ipNum) >= 0 {
+		return &arr[idx-1]
+	}
+	return nil
+}
+
+// geoFeature is a GeoJSON Feature (RFC 7946) placing a lookup result at
+// its city's coordinates
+type geoFeature struct {
+	Type       string        `json:"type"`
+	Geometry   geoPoint      `json:"geometry"`
+	Properties geoProperties `json:"properties"`
+}
+
+// geoPoint is a GeoJSON Point. Coordinates are longitude first.
+type geoPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type geoProperties struct {
+	IpAddr  string  `json:"ip_addr"`
+	Country string  `json:"country"`
+	City    *string `json:"city"`
+}
+
+// wantsGeoJSON reports whether the client asked for ?format=geojson
+func wantsGeoJSON(c *gin.Context) bool {
+	return c.Query("format") == "geojson"
+}
+
+// renderGeoJSON writes resp as a GeoJSON Feature. Lookups that didn't
+// match are written as usual, since there is nothing to place, and matches
+// without coordinates, because CITY_CSV isn't set or has no block for the
+// address, get a 404 no_coordinates.
+func (a *app) renderGeoJSON(c *gin.Context, resp ApiResponse) {
+	if !resp.Ok || resp.IpAddr == nil || resp.Country == nil {
+		renderLookup(c, resp)
+		return
+	}
+	block := findCityRange(a.cityArr, ipToNum(net.ParseIP(*resp.IpAddr)))
+	if block == nil {
+		respondError(c, http.StatusNotFound, codeNoCoordinates, "no coordinates are known for "+*resp.IpAddr)
+		return
+	}
+	feature := geoFeature{
+		Type:       "Feature",
+		Geometry:   geoPoint{Type: "Point", Coordinates: [2]float64{block.lon, block.lat}},
+		Properties: geoProperties{IpAddr: *resp.IpAddr, Country: *resp.Country},
+	}
+	if block.city != "" {
+		feature.Properties.City = &block.city
+	}
+	c.Header("Content-Type", "application/geo+json")
+	c.JSON(http.StatusOK, feature)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadCityCsv(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "city.csv", "134744064,134744319,US,California,,Mountain View,94043,37.4223,-122.084,America/Los_Angeles\n"+
+		"16777216,16777471,AU,Queensland,,Brisbane,,-27.4679,153.0281\n"+
+		"16777472,16777727,CN,Fujian,,Fuzhou,,x,119.3\n"+
+		"16777728,16777983,CN,Fujian,,Fuzhou,,26.06,190\n"+
+		"16778240,16778495,AU\n")
+	arr := loadCityCsv(path)
+	if len(arr) != 2 {
+		t.Fatalf("loaded %d city blocks, want the 2 with valid coordinates", len(arr))
+	}
+	if block := findCityRange(arr, bigNum("16777300")); block == nil || block.city != "Brisbane" || block.lat != -27.4679 || block.lon != 153.0281 {
+		t.Errorf("findCityRange(1.0.0.84) = %+v, want Brisbane", block)
+	}
+	if block := findCityRange(arr, bigNum("16777500")); block != nil {
+		t.Errorf("findCityRange(1.0.1.28) = %+v, want none for a skipped row", block)
+	}
+}
+
+// checkGeoJSONPoint checks body against the GeoJSON schema of a Feature
+// with a Point geometry (RFC 7946 sections 3.1.2 and 3.2, as in
+// geojson.org/schema/Feature.json): "type", "geometry" and "properties"
+// members, a position of two or three numbers, longitude first, and an
+// object or null for properties
+func checkGeoJSONPoint(t *testing.T, body []byte) map[string]any {
+	t.Helper()
+	var feature map[string]any
+	if err := json.Unmarshal(body, &feature); err != nil {
+		t.Fatalf("body is not JSON: %v: %s", err, body)
+	}
+	if feature["type"] != "Feature" {
+		t.Errorf("type = %v, want Feature", feature["type"])
+	}
+	geometry, ok := feature["geometry"].(map[string]any)
+	if !ok {
+		t.Fatalf("geometry = %v, want an object", feature["geometry"])
+	}
+	if geometry["type"] != "Point" {
+		t.Errorf("geometry.type = %v, want Point", geometry["type"])
+	}
+	position, ok := geometry["coordinates"].([]any)
+	if !ok || len(position) < 2 || len(position) > 3 {
+		t.Fatalf("geometry.coordinates = %v, want a position of 2 or 3 numbers", geometry["coordinates"])
+	}
+	for i, v := range position {
+		if _, ok := v.(float64); !ok {
+			t.Errorf("geometry.coordinates[%d] = %v, want a number", i, v)
+		}
+	}
+	if lon, lat := position[0].(float64), position[1].(float64); lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+		t.Errorf("position = [%v, %v], want longitude then latitude in range", lon, lat)
+	}
+	properties, ok := feature["properties"].(map[string]any)
+	if !ok && feature["properties"] != nil {
+		t.Errorf("properties = %v, want an object or null", feature["properties"])
+	}
+	return properties
+}
+
+func TestGetIpInfoGeoJSON(t *testing.T) {
+	a := newTestApp(t, "16777216-16777471:AU", "16777472-16777727:CN")
+	a.cityArr = loadCityCsv(writeTestFile(t, t.TempDir(), "city.csv", "16777216,16777471,AU,Queensland,,Brisbane,,-27.4679,153.0281\n"))
+
+	w := serveTest(a.getIpInfo, httptest.NewRequest("GET", "/getIpInfo?addr=1.0.0.1&format=geojson", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/geo+json" {
+		t.Fatalf("status %d, Content-Type %q, want 200 application/geo+json: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	properties := checkGeoJSONPoint(t, w.Body.Bytes())
+	if properties["ip_addr"] != "1.0.0.1" || properties["country"] != "AU" || properties["city"] != "Brisbane" {
+		t.Errorf("properties = %v, want 1.0.0.1 in Brisbane, AU", properties)
+	}
+
+	w = serveTest(a.getIpInfo, httptest.NewRequest("GET", "/getIpInfo?num=16777216&format=geojson", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("num lookup: status %d, want 200: %s", w.Code, w.Body)
+	}
+
+	for _, tt := range []struct {
+		target string
+		status int
+		code   string
+	}{
+		{target: "/getIpInfo?addr=1.0.1.1&format=geojson", status: http.StatusNotFound, code: codeNoCoordinates},
+		{target: "/getIpInfo?addr=1.0.0.1&addr=1.0.1.1&format=geojson", status: http.StatusBadRequest, code: codeInvalidRequest},
+	} {
+		w := serveTest(a.getIpInfo, httptest.NewRequest("GET", tt.target, nil))
+		var body errorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != tt.status || body.Error.Code != tt.code {
+			t.Errorf("%s: status %d, body %s, want %d %s", tt.target, w.Code, w.Body, tt.status, tt.code)
+		}
+	}
+
+	// Without CITY_CSV no match has coordinates
+	a.cityArr = nil
+	w = serveTest(a.getIpInfo, httptest.NewRequest("GET", "/getIpInfo?addr=1.0.0.1&format=geojson", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("without city data: status %d, want 404: %s", w.Code, w.Body)
+	}
+
+	// A miss has nothing to place and is answered as usual
+	w = serveTest(a.getIpInfo, httptest.NewRequest("GET", "/getIpInfo?addr=9.9.9.9&format=geojson", nil))
+	var miss ApiResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &miss); err != nil || w.Code != http.StatusOK || miss.Error != codeNotFound {
+		t.Errorf("miss: status %d, body %s, want the usual not_found result", w.Code, w.Body)
+	}
+}
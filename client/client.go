@@ -0,0 +1,189 @@
+// Package client is a Go client for the IP geolocation API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Errors returned by Lookup for the service's lookup error codes
+var (
+	// ErrInvalidIP means the input isn't a valid IPv4 or IPv6 address
+	ErrInvalidIP = errors.New("invalid IP address")
+	// ErrReserved means the address is in a special-purpose range; the
+	// returned Result carries its Category
+	ErrReserved = errors.New("reserved IP address")
+	// ErrNotFound means the address is valid but no range covers it
+	ErrNotFound = errors.New("IP address not found")
+)
+
+// APIError is a failed request, as reported by the service's error
+// envelope {"ok":false,"error":{"code":...,"message":...}}
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("ip-geo-api: %s (%d): %s", e.Code, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("ip-geo-api: %s (%d)", e.Code, e.StatusCode)
+}
+
+var codeErrors = map[string]error{
+	"invalid_ip": ErrInvalidIP,
+	"reserved":   ErrReserved,
+	"not_found":  ErrNotFound,
+}
+
+// Result is a single lookup response
+type Result struct {
+	Ok       bool    `json:"ok"`
+	Country  *string `json:"country"`
+	IpAddr   *string `json:"ip_addr"`
+	IpV6     bool    `json:"ip_v6"`
+	Reserved bool    `json:"reserved"`
+	Category *string `json:"category"`
+	// Error is the lookup error code when Ok is false
+	Error string `json:"error"`
+}
+
+// errorEnvelope is the body of every failed request
+type errorEnvelope struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Client calls the API at BaseURL, e.g. "http://localhost:8080"
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// New returns a Client for baseURL using http.DefaultClient
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// Lookup geolocates addr. Lookups that fail return ErrInvalidIP,
+// ErrReserved or ErrNotFound (check with errors.Is); ErrReserved comes with
+// the Result so its Category can be inspected.
+func (c *Client) Lookup(ctx context.Context, addr string) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.BaseURL+"/getIpInfo?addr="+url.QueryEscape(addr), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeAPIError(resp.StatusCode, body)
+	}
+	var res Result
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	// Servers with RESERVED_OK report reserved addresses as ok
+	if res.Reserved {
+		return &res, ErrReserved
+	}
+	if res.Ok {
+		return &res, nil
+	}
+	if err, ok := codeErrors[res.Error]; ok {
+		return &res, err
+	}
+	return nil, &APIError{StatusCode: resp.StatusCode, Code: res.Error}
+}
+
+// countryRecordsType is the media type of the binary batch response
+const countryRecordsType = "application/x-ipgeo-countries"
+
+// LookupCountries geolocates addrs in one batch request, using the compact
+// binary response. It returns the country of each address in input order,
+// "" for addresses without one, whether invalid, reserved or not found. The
+// server limits the batch to its MAX_BATCH.
+func (c *Client) LookupCountries(ctx context.Context, addrs []string) ([]string, error) {
+	body, err := json.Marshal(addrs)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/getIpInfoBatch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", countryRecordsType)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeAPIError(resp.StatusCode, b)
+	}
+	countries, err := DecodeCountryRecords(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(countries) != len(addrs) {
+		return nil, fmt.Errorf("decoding response: %d records for %d addresses", len(countries), len(addrs))
+	}
+	return countries, nil
+}
+
+// DecodeCountryRecords decodes a binary batch response: 3-byte records of a
+// match flag, 1 for a match and 0 otherwise, and the two-letter country
+// code, zeroed without a match. Unmatched records decode to "".
+func DecodeCountryRecords(b []byte) ([]string, error) {
+	if len(b)%3 != 0 {
+		return nil, fmt.Errorf("decoding response: %d bytes isn't a whole number of records", len(b))
+	}
+	countries := make([]string, len(b)/3)
+	for i := range countries {
+		rec := b[i*3 : i*3+3]
+		switch rec[0] {
+		case 0:
+		case 1:
+			countries[i] = string(rec[1:3])
+		default:
+			return nil, fmt.Errorf("decoding response: record %d has match flag %d", i, rec[0])
+		}
+	}
+	return countries, nil
+}
+
+// decodeAPIError reads the error envelope of a failed request. A body that
+// isn't one, such as a proxy's error page, gives an APIError with only the
+// status code.
+func decodeAPIError(status int, body []byte) *APIError {
+	var envelope errorEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error.Code == "" {
+		return &APIError{StatusCode: status, Code: http.StatusText(status)}
+	}
+	return &APIError{StatusCode: status, Code: envelope.Error.Code, Message: envelope.Error.Message}
+}
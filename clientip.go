@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIP returns the address of the real client. With TrustedProxyHops
+// set to N, the client is taken as the Nth entry from the right of the
+// ClientIPHeader list, i.e. the address the outermost trusted proxy saw.
+// Anything that doesn't fit that configuration (a missing header, fewer
+// entries than hops, an unparsable entry) falls back to the peer address.
+func clientIP(cfg *Config, r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if cfg.TrustedProxyHops <= 0 {
+		return peer
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values(cfg.ClientIPHeader) {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	if len(hops) < cfg.TrustedProxyHops {
+		return peer
+	}
+	candidate := hops[len(hops)-cfg.TrustedProxyHops]
+	if net.ParseIP(candidate) == nil {
+		return peer
+	}
+	return candidate
+}
+
+// myIp geolocates the caller's own address
+func (a *app) myIp(c *gin.Context) {
+	renderLookup(c, a.resolveVerbose(c, a.current(), clientIP(a.cfg, c.Request)))
+}
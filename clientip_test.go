@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		hops       int
+		header     string
+		xff        []string // X-Forwarded-For values, one header line each
+		remoteAddr string
+		want       string
+	}{
+		{name: "no hops ignores the header", hops: 0, xff: []string{"1.1.1.1, 10.0.0.1"}, want: "192.0.2.9"},
+		{name: "one hop", hops: 1, xff: []string{"1.1.1.1, 2.2.2.2, 10.0.0.1"}, want: "10.0.0.1"},
+		{name: "two hops", hops: 2, xff: []string{"1.1.1.1, 2.2.2.2, 10.0.0.1"}, want: "2.2.2.2"},
+		{name: "two hops over two header lines", hops: 2, xff: []string{"1.1.1.1, 2.2.2.2", "10.0.0.1"}, want: "2.2.2.2"},
+		{name: "two hops with a forged entry", hops: 2, xff: []string{"6.6.6.6, 1.1.1.1, 10.0.0.1"}, want: "1.1.1.1"},
+		{name: "ipv6 entry", hops: 1, xff: []string{"1.1.1.1, 2001:4860::8888"}, want: "2001:4860::8888"},
+		{name: "header shorter than hops", hops: 3, xff: []string{"1.1.1.1, 10.0.0.1"}, want: "192.0.2.9"},
+		{name: "unparsable entry", hops: 2, xff: []string{"1.1.1.1, unknown, 10.0.0.1"}, want: "192.0.2.9"},
+		{name: "missing header", hops: 1, want: "192.0.2.9"},
+		{name: "only the configured header is read", hops: 1, header: "X-Real-Ip", xff: []string{"1.1.1.1"}, want: "192.0.2.9"},
+		{name: "remote addr without a port", hops: 0, remoteAddr: "192.0.2.10", want: "192.0.2.10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{TrustedProxyHops: tt.hops, ClientIPHeader: "X-Forwarded-For"}
+			if tt.header != "" {
+				cfg.ClientIPHeader = tt.header
+			}
+			r := httptest.NewRequest("GET", "/myip", nil)
+			r.RemoteAddr = "192.0.2.9:51234"
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(cfg, r); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
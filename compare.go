@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compareSide is one address of /compare: its lookup result plus the
+// continent its country is in, null when there is none
+type compareSide struct {
+	ApiResponse
+	Continent *string `json:"continent"`
+}
+
+type compareResponse struct {
+	// Ok is set when both addresses resolved to a country
+	Ok bool        `json:"ok"`
+	A  compareSide `json:"a"`
+	B  compareSide `json:"b"`
+	// SameCountry and SameContinent are false unless both sides resolved,
+	// so a miss or a reserved address never matches
+	SameCountry   bool `json:"same_country"`
+	SameContinent bool `json:"same_continent"`
+}
+
+// compare looks up ?a= and ?b= and reports whether they are in the same
+// country and continent, as for checking a login IP against a billing one
+func (a *app) compare(c *gin.Context) {
+	rawA, rawB := c.Query("a"), c.Query("b")
+	if rawA == "" || rawB == "" {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "a and b are both required")
+		return
+	}
+	data := a.current()
+	sideA, sideB := a.compareSide(c, data, rawA), a.compareSide(c, data, rawB)
+	resp := compareResponse{Ok: sideA.matched() && sideB.matched(), A: sideA, B: sideB}
+	if resp.Ok {
+		resp.SameCountry = *sideA.Country == *sideB.Country
+		resp.SameContinent = sideA.Continent != nil && sideB.Continent != nil && *sideA.Continent == *sideB.Continent
+	}
+	renderJSON(c, http.StatusOK, resp)
+}
+
+// matched tells whether the side resolved to a country, which a reserved
+// address doesn't even when RESERVED_OK makes it ok
+func (side compareSide) matched() bool {
+	return side.Ok && !side.Reserved
+}
+
+func (a *app) compareSide(c *gin.Context, data *dataset, raw string) compareSide {
+	side := compareSide{ApiResponse: a.resolveVerbose(c, data, raw)}
+	if side.matched() {
+		if i := continentIndex(*side.Country); i >= 0 {
+			name := continentNames.Name(continents[i])
+			side.Continent = &name
+		}
+	}
+	return side
+}
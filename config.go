@@ -0,0 +1,935 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Config holds all runtime settings. It is read once at startup from the
+// environment, falling back to the optional config file, and passed to the
+// components that need it.
+type Config struct {
+	DataDir string
+	// Files are the CSV backend's data files: defaultFiles, or the list of
+	// the config file
+	Files       []fileInfo
+	DataBackend string
+	SqlitePath  string
+	SqliteTable string
+	// DelegatedFiles are the delegated-stats files read with
+	// DATA_BACKEND=rir, earlier files winning exact duplicates
+	DelegatedFiles []string
+	AutoUpdate     bool
+	// GithubAPIBase is where file metadata is fetched from, and
+	// GithubRawBase where the files themselves are downloaded from
+	GithubAPIBase string
+	GithubRawBase string
+	// DataArchiveURL, if set, is a .zip or .tar.gz of the whole dataset that
+	// updates extract the data files from instead
+	DataArchiveURL string
+	// AutoUpdateDryRun checks for changed files without downloading them
+	AutoUpdateDryRun bool
+	// AutoUpdateInterval re-runs the update check periodically, give or take
+	// AutoUpdateJitter (a fraction of the interval)
+	AutoUpdateInterval time.Duration
+	AutoUpdateJitter   float64
+	MaxDataAge         time.Duration
+	EnableIpv4         bool
+	EnableIpv6         bool
+	RirCsv             string
+	CityCsv            string
+
+	// StartupGate serves requests while the first load is still retried,
+	// /ready failing until it succeeds. After StartupTimeout the process
+	// exits, or with StartupTimeoutAction "fallback" serves the files
+	// already on disk without updating them.
+	StartupGate          bool
+	StartupTimeout       time.Duration
+	StartupTimeoutAction string
+	// LoadChain lists the loadSteps tried in turn for the first load,
+	// network by default
+	LoadChain []string
+
+	// OutboundTimeout bounds every download and upstream call, made through
+	// the proxy from the environment and trusting OutboundCAFile as well as
+	// the system's CAs
+	OutboundTimeout time.Duration
+	OutboundCAFile  string
+	// outbound is the client built from them, for all outbound requests
+	outbound *http.Client
+
+	// SourceAccuracy maps a source name to the accuracy reported for its
+	// matches under verbose mode
+	SourceAccuracy map[string]string
+	// SourceLicense and SourceAttribution override the licensing metadata
+	// served by /attribution per source, and AttributionHeader sends the
+	// texts with every lookup
+	SourceLicense     map[string]string
+	SourceAttribution map[string]string
+	AttributionHeader bool
+	// Enrichers names the Enrichers run on lookup results, in order, and
+	// EnrichTags maps prefixes to the tags of the tags enricher
+	Enrichers  []string
+	EnrichTags map[string]string
+	// RequiredSources lists sources whose files must load for startup to
+	// succeed
+	RequiredSources map[string]bool
+	// DisabledSources lists CSV sources left out of the dataset, which the
+	// admin endpoints change at runtime
+	DisabledSources map[string]bool
+	// NumberBase maps a source to the base of its start/end columns: 10,
+	// 16 or auto. Sources not listed are decimal.
+	NumberBase map[string]string
+	// Columns maps a source to its start:end:country column indexes.
+	// Sources not listed use 0:1:2.
+	Columns map[string]string
+	// AddrColumns maps a source to the start:end columns holding the same
+	// bounds as addresses, read where the numeric columns don't parse
+	AddrColumns map[string]string
+	// RangeFormat maps a source to whether its end column holds ends
+	// (start-end, the default), prefix lengths (start-prefix) or either,
+	// told apart per file (auto)
+	RangeFormat map[string]string
+	// Delimiter and Comment map a source to its field delimiter and comment
+	// character, each a single character or a name such as tab
+	Delimiter map[string]string
+	Comment   map[string]string
+
+	// UnknownCountry, when set, replaces the null country of failed lookups
+	UnknownCountry string
+	// ReservedOk reports special-purpose addresses with ok true and no
+	// error, as classified rather than failed
+	ReservedOk bool
+
+	// FallbackURL is an upstream geo API queried for local misses, with
+	// {ip} standing for the address
+	FallbackURL          string
+	FallbackKey          string
+	FallbackCountryField string
+	FallbackTimeout      time.Duration
+	FallbackCacheTTL     time.Duration
+
+	// RedisURL enables a lookup cache shared by the instances using the
+	// same Redis, entries expiring after RedisTTL
+	RedisURL     string
+	RedisTTL     time.Duration
+	RedisTimeout time.Duration
+
+	LogLevel  slog.Level
+	LogFormat string
+	LogMisses bool
+	// LogMissesSample records one miss in every LogMissesSample, and
+	// LogMissesMaxPrefixes caps the prefixes counted between flushes
+	LogMissesSample      int
+	LogMissesMaxPrefixes int
+	// AnonymizeIPs masks client IPs in log output; lookups still use the
+	// full address
+	AnonymizeIPs bool
+
+	CanaryIp       string
+	CanaryCountry  string
+	DevEndpoints   bool
+	MetricsEnabled bool
+	// MetricsExemplars attaches trace IDs from incoming traceparent headers
+	// to the lookup latency histogram
+	MetricsExemplars bool
+	AdminToken       string
+	// RequiredHeader, if set, must be present on every request but health
+	// checks and metrics scrapes, with the value RequiredHeaderValue when
+	// that is set too
+	RequiredHeader      string
+	RequiredHeaderValue string
+
+	ListenAddr string
+	// TCPAddr is where the line protocol is served, empty when disabled
+	TCPAddr           string
+	BasePath          string
+	OpsAtRoot         bool
+	ClientIPHeader    string
+	TrustedProxyHops  int
+	TLSCertFile       string
+	TLSKeyFile        string
+	KeepAlive         bool
+	IdleTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration
+	MaxConnections    int
+	MaxConcurrent     int
+	RequestTimeout    time.Duration
+	MaxUploadBytes    int64
+	MaxBatch          int
+	MaxIpv6SpanBits   int
+	MaxCidrSegments   int
+	MaxResultRows     int
+	// BatchHostnames lets batch inputs be hostnames, resolved with
+	// DNSTimeout each and at most MaxBatchHostnames per request
+	BatchHostnames    bool
+	DNSTimeout        time.Duration
+	MaxBatchHostnames int
+
+	// MaxResultRowsByEndpoint overrides MaxResultRows for single endpoints
+	MaxResultRowsByEndpoint map[string]string
+}
+
+// loadConfig reads the Config from the environment and the config file at
+// path, if any, and validates it. Env vars override file values, and
+// overrides, keyed like env vars, override both.
+func loadConfig(path string, overrides map[string]string) (*Config, error) {
+	e := &envReader{override: overrides}
+	var fileList []fileInfo
+	if path != "" {
+		cf, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		e.file = cf.values
+		fileList = cf.files
+	}
+	cfg := &Config{
+		DataDir:            e.string("DATA_DIR", "/app/data"),
+		Files:              defaultFiles,
+		DataBackend:        strings.ToLower(e.string("DATA_BACKEND", "csv")),
+		SqlitePath:         e.string("SQLITE_PATH", ""),
+		SqliteTable:        e.string("SQLITE_TABLE", "ranges"),
+		DelegatedFiles:     e.list("RIR_DELEGATED_FILES"),
+		AutoUpdate:         e.bool("AUTO_UPDATE", false),
+		GithubAPIBase:      strings.TrimSuffix(e.string("GITHUB_API_BASE", defaultGithubAPIBase), "/"),
+		GithubRawBase:      strings.TrimSuffix(e.string("GITHUB_RAW_BASE", ""), "/"),
+		DataArchiveURL:     e.string("DATA_ARCHIVE_URL", ""),
+		AutoUpdateDryRun:   e.bool("AUTO_UPDATE_DRY_RUN", false),
+		AutoUpdateInterval: e.duration("AUTO_UPDATE_INTERVAL", 0),
+		AutoUpdateJitter:   e.float("AUTO_UPDATE_JITTER", 0.1),
+		MaxDataAge:         e.duration("MAX_DATA_AGE", 30*24*time.Hour),
+		EnableIpv4:         e.bool("ENABLE_IPV4", true),
+		EnableIpv6:         e.bool("ENABLE_IPV6", true),
+		RirCsv:             e.string("RIR_CSV", ""),
+		CityCsv:            e.string("CITY_CSV", ""),
+
+		StartupGate:          e.bool("STARTUP_GATE", false),
+		StartupTimeout:       e.duration("STARTUP_TIMEOUT", 5*time.Minute),
+		StartupTimeoutAction: strings.ToLower(e.string("STARTUP_TIMEOUT_ACTION", "exit")),
+		LoadChain:            e.list("LOAD_CHAIN"),
+
+		OutboundTimeout: e.duration("OUTBOUND_TIMEOUT", 10*time.Minute),
+		OutboundCAFile:  e.string("OUTBOUND_CA_FILE", ""),
+
+		UnknownCountry: e.string("UNKNOWN_COUNTRY_CODE", ""),
+		ReservedOk:     e.bool("RESERVED_OK", false),
+
+		FallbackURL:          e.string("FALLBACK_URL", ""),
+		FallbackKey:          e.string("FALLBACK_KEY", ""),
+		FallbackCountryField: e.string("FALLBACK_COUNTRY_FIELD", "country"),
+		FallbackTimeout:      e.duration("FALLBACK_TIMEOUT", 500*time.Millisecond),
+		FallbackCacheTTL:     e.duration("FALLBACK_CACHE_TTL", 24*time.Hour),
+
+		RedisURL:     e.string("REDIS_URL", ""),
+		RedisTTL:     e.duration("REDIS_TTL", time.Hour),
+		RedisTimeout: e.duration("REDIS_TIMEOUT", 50*time.Millisecond),
+
+		SourceAccuracy:  e.stringMap("SOURCE_ACCURACY"),
+		RequiredSources: e.set("REQUIRED_SOURCES"),
+		DisabledSources: e.set("DISABLED_SOURCES"),
+		NumberBase:      e.stringMap("CSV_NUMBER_BASE"),
+		Columns:         e.stringMap("CSV_COLUMNS"),
+		AddrColumns:     e.stringMap("CSV_ADDR_COLUMNS"),
+		RangeFormat:     e.stringMap("CSV_RANGE_FORMAT"),
+		Delimiter:       e.stringMap("CSV_DELIMITER"),
+		Comment:         e.stringMap("CSV_COMMENT"),
+
+		SourceLicense:     e.stringMap("SOURCE_LICENSE"),
+		SourceAttribution: e.stringMap("SOURCE_ATTRIBUTION"),
+		AttributionHeader: e.bool("ATTRIBUTION_HEADER", false),
+
+		Enrichers:  e.list("ENRICHERS"),
+		EnrichTags: e.stringMap("ENRICH_TAGS"),
+
+		LogLevel:  e.level("LOG_LEVEL", slog.LevelInfo),
+		LogFormat: strings.ToLower(e.string("LOG_FORMAT", "text")),
+		LogMisses: e.bool("LOG_MISSES", false),
+
+		LogMissesSample:      e.int("LOG_MISSES_SAMPLE", 1),
+		LogMissesMaxPrefixes: e.int("LOG_MISSES_MAX_PREFIXES", 10000),
+
+		AnonymizeIPs: e.bool("ANONYMIZE_IPS", false),
+
+		CanaryIp:         e.string("HEALTH_CANARY_IP", ""),
+		CanaryCountry:    e.string("HEALTH_CANARY_COUNTRY", "US"),
+		DevEndpoints:     e.bool("DEV_ENDPOINTS", false),
+		MetricsEnabled:   e.bool("METRICS_ENABLED", true),
+		MetricsExemplars: e.bool("METRICS_EXEMPLARS", false),
+		AdminToken:       e.string("ADMIN_TOKEN", ""),
+
+		RequiredHeader:      e.string("REQUIRED_HEADER", ""),
+		RequiredHeaderValue: e.string("REQUIRED_HEADER_VALUE", ""),
+
+		ListenAddr:        ":" + e.string("PORT", "8080"),
+		TCPAddr:           e.string("TCP_PORT", ""),
+		BasePath:          basePath(e.string("BASE_PATH", "")),
+		OpsAtRoot:         e.bool("OPS_AT_ROOT", false),
+		ClientIPHeader:    e.string("CLIENT_IP_HEADER", "X-Forwarded-For"),
+		TrustedProxyHops:  e.int("TRUSTED_PROXY_HOPS", 0),
+		TLSCertFile:       e.string("TLS_CERT_FILE", ""),
+		TLSKeyFile:        e.string("TLS_KEY_FILE", ""),
+		KeepAlive:         e.bool("HTTP_KEEPALIVE", true),
+		IdleTimeout:       e.duration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		ReadHeaderTimeout: e.duration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		MaxHeaderBytes:    e.int("MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes),
+		ShutdownTimeout:   e.duration("SHUTDOWN_TIMEOUT", 15*time.Second),
+		MaxConnections:    e.int("MAX_CONNECTIONS", 0),
+		MaxConcurrent:     e.int("MAX_CONCURRENT", 0),
+		RequestTimeout:    e.duration("REQUEST_TIMEOUT", 0),
+		MaxUploadBytes:    int64(e.int("MAX_UPLOAD_BYTES", defaultMaxUploadBytes)),
+		MaxBatch:          e.int("MAX_BATCH", 100),
+		MaxIpv6SpanBits:   e.int("MAX_IPV6_SPAN_BITS", 64),
+		MaxCidrSegments:   e.int("MAX_CIDR_SEGMENTS", 10000),
+		MaxResultRows:     e.int("MAX_RESULT_ROWS", 10000),
+		BatchHostnames:    e.bool("BATCH_HOSTNAMES", false),
+		DNSTimeout:        e.duration("DNS_TIMEOUT", 2*time.Second),
+		MaxBatchHostnames: e.int("MAX_BATCH_HOSTNAMES", 20),
+
+		MaxResultRowsByEndpoint: e.stringMap("MAX_RESULT_ROWS_BY_ENDPOINT"),
+	}
+	if cfg.TCPAddr != "" {
+		cfg.TCPAddr = ":" + cfg.TCPAddr
+	}
+	if cfg.GithubRawBase == "" {
+		cfg.GithubRawBase = githubRawBase(cfg.GithubAPIBase)
+	}
+	if fileList != nil {
+		cfg.Files = fileList
+	}
+	if len(cfg.LoadChain) == 0 {
+		cfg.LoadChain = []string{"network"}
+	}
+	if cfg.CanaryIp == "" {
+		// Google's public DNS resolver, in whichever family is served
+		cfg.CanaryIp = "8.8.8.8"
+		if !cfg.EnableIpv4 {
+			cfg.CanaryIp = "2001:4860:4860::8888"
+		}
+	}
+	e.checkFileKeys(path)
+	e.checkOverrideKeys()
+	if err := errors.Join(append(e.errs, cfg.validate())...); err != nil {
+		return nil, err
+	}
+	outbound, err := newOutboundClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.outbound = outbound
+	return cfg, nil
+}
+
+const defaultGithubAPIBase = "https://api.github.com"
+
+// githubRawBase derives the raw download base from a GitHub API base: the
+// public API downloads from raw.githubusercontent.com and GitHub Enterprise
+// (https://host/api/v3) from https://host/raw. Other API bases give "", as
+// there is no telling where a mirror serves files from.
+func githubRawBase(apiBase string) string {
+	if apiBase == defaultGithubAPIBase {
+		return "https://raw.githubusercontent.com"
+	}
+	if host, ok := strings.CutSuffix(apiBase, "/api/v3"); ok {
+		return host + "/raw"
+	}
+	return ""
+}
+
+func (cfg *Config) validate() error {
+	var errs []error
+	if cfg.DataDir == "" {
+		errs = append(errs, errors.New("DATA_DIR must not be empty"))
+	}
+	switch cfg.DataBackend {
+	case "csv":
+	case "sqlite":
+		if cfg.SqlitePath == "" {
+			errs = append(errs, errors.New("SQLITE_PATH is required with DATA_BACKEND=sqlite"))
+		}
+		if !identifierRe.MatchString(cfg.SqliteTable) {
+			errs = append(errs, fmt.Errorf("SQLITE_TABLE must be a plain identifier, got %q", cfg.SqliteTable))
+		}
+	case "rir":
+		if len(cfg.DelegatedFiles) == 0 {
+			errs = append(errs, errors.New("RIR_DELEGATED_FILES is required with DATA_BACKEND=rir"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("DATA_BACKEND must be csv, sqlite or rir, got %q", cfg.DataBackend))
+	}
+	if cfg.AutoUpdateInterval < 0 {
+		errs = append(errs, errors.New("AUTO_UPDATE_INTERVAL must not be negative"))
+	}
+	if cfg.AutoUpdateInterval > 0 && !cfg.AutoUpdate && !cfg.AutoUpdateDryRun {
+		errs = append(errs, errors.New("AUTO_UPDATE_INTERVAL needs AUTO_UPDATE or AUTO_UPDATE_DRY_RUN"))
+	}
+	for i, step := range cfg.LoadChain {
+		if loadSteps[step] == nil {
+			errs = append(errs, fmt.Errorf("LOAD_CHAIN: unknown step %q, must be network or local", step))
+		} else if slices.Contains(cfg.LoadChain[:i], step) {
+			errs = append(errs, fmt.Errorf("LOAD_CHAIN: step %q is listed twice", step))
+		}
+	}
+	if cfg.OutboundTimeout <= 0 {
+		errs = append(errs, errors.New("OUTBOUND_TIMEOUT must be positive"))
+	}
+	if cfg.StartupTimeout <= 0 {
+		errs = append(errs, errors.New("STARTUP_TIMEOUT must be positive"))
+	}
+	if cfg.StartupTimeoutAction != "exit" && cfg.StartupTimeoutAction != "fallback" {
+		errs = append(errs, fmt.Errorf("STARTUP_TIMEOUT_ACTION must be exit or fallback, got %q", cfg.StartupTimeoutAction))
+	}
+	if cfg.AutoUpdateJitter < 0 || cfg.AutoUpdateJitter >= 1 {
+		errs = append(errs, errors.New("AUTO_UPDATE_JITTER must be at least 0 and less than 1"))
+	}
+	if !cfg.EnableIpv4 && !cfg.EnableIpv6 {
+		errs = append(errs, errors.New("ENABLE_IPV4 and ENABLE_IPV6 must not both be false"))
+	}
+	for name := range cfg.SourceAccuracy {
+		if !cfg.knownSource(name) {
+			errs = append(errs, fmt.Errorf("SOURCE_ACCURACY: unknown source %q", name))
+		}
+	}
+	// The other backends name their single source after themselves
+	for key, m := range map[string]map[string]string{"SOURCE_LICENSE": cfg.SourceLicense, "SOURCE_ATTRIBUTION": cfg.SourceAttribution} {
+		for name := range m {
+			if !cfg.knownSource(name) && name != "sqlite" && name != "rir-delegated" {
+				errs = append(errs, fmt.Errorf("%s: unknown source %q", key, name))
+			}
+		}
+	}
+	for i, name := range cfg.Enrichers {
+		if enricherFactories[name] == nil {
+			errs = append(errs, fmt.Errorf("ENRICHERS: unknown enricher %q", name))
+		} else if slices.Contains(cfg.Enrichers[:i], name) {
+			errs = append(errs, fmt.Errorf("ENRICHERS: enricher %q is listed twice", name))
+		}
+	}
+	for raw := range cfg.EnrichTags {
+		if _, err := netip.ParsePrefix(raw); err != nil {
+			errs = append(errs, fmt.Errorf("ENRICH_TAGS: invalid prefix %q", raw))
+		}
+	}
+	if len(cfg.EnrichTags) > 0 && !slices.Contains(cfg.Enrichers, "tags") {
+		errs = append(errs, errors.New("ENRICH_TAGS needs the tags enricher in ENRICHERS"))
+	}
+	for name := range cfg.DisabledSources {
+		if !cfg.knownSource(name) {
+			errs = append(errs, fmt.Errorf("DISABLED_SOURCES: unknown source %q", name))
+		}
+	}
+	if cfg.DataBackend == "csv" && !slices.ContainsFunc(cfg.Files, func(fi fileInfo) bool { return cfg.fileEnabled(&fi) }) {
+		errs = append(errs, errors.New("DISABLED_SOURCES and ENABLE_IPV4/ENABLE_IPV6 must leave a file to load"))
+	}
+	for name := range cfg.RequiredSources {
+		if !cfg.knownSource(name) {
+			errs = append(errs, fmt.Errorf("REQUIRED_SOURCES: unknown source %q", name))
+		}
+	}
+	for name, base := range cfg.NumberBase {
+		if !cfg.knownSource(name) {
+			errs = append(errs, fmt.Errorf("CSV_NUMBER_BASE: unknown source %q", name))
+		}
+		if base != "10" && base != "16" && base != "auto" {
+			errs = append(errs, fmt.Errorf("CSV_NUMBER_BASE: base for %q must be 10, 16 or auto, got %q", name, base))
+		}
+	}
+	for name, cols := range cfg.Columns {
+		if !cfg.knownSource(name) {
+			errs = append(errs, fmt.Errorf("CSV_COLUMNS: unknown source %q", name))
+		}
+		if _, err := parseColumns(cols); err != nil {
+			errs = append(errs, fmt.Errorf("CSV_COLUMNS: %q: %w", name, err))
+		}
+	}
+	for name, cols := range cfg.AddrColumns {
+		if !cfg.knownSource(name) {
+			errs = append(errs, fmt.Errorf("CSV_ADDR_COLUMNS: unknown source %q", name))
+		}
+		if _, err := parseAddrColumns(cols); err != nil {
+			errs = append(errs, fmt.Errorf("CSV_ADDR_COLUMNS: %q: %w", name, err))
+		}
+	}
+	for name, format := range cfg.RangeFormat {
+		if !cfg.knownSource(name) {
+			errs = append(errs, fmt.Errorf("CSV_RANGE_FORMAT: unknown source %q", name))
+		}
+		if format != "start-end" && format != "start-prefix" && format != "auto" {
+			errs = append(errs, fmt.Errorf("CSV_RANGE_FORMAT: format for %q must be start-end, start-prefix or auto, got %q", name, format))
+		}
+	}
+	for _, opt := range []struct {
+		key    string
+		values map[string]string
+	}{{"CSV_DELIMITER", cfg.Delimiter}, {"CSV_COMMENT", cfg.Comment}} {
+		for name, value := range opt.values {
+			if !cfg.knownSource(name) {
+				errs = append(errs, fmt.Errorf("%s: unknown source %q", opt.key, name))
+			}
+			if _, err := parseCsvChar(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q: %w", opt.key, name, err))
+			}
+		}
+	}
+	for i := range cfg.Files {
+		if format := cfg.csvFormat(&cfg.Files[i]); format.comma == format.comment {
+			errs = append(errs, fmt.Errorf("CSV_DELIMITER and CSV_COMMENT for %q must differ", cfg.Files[i].Source()))
+		}
+	}
+	if canary := net.ParseIP(cfg.CanaryIp); canary == nil {
+		errs = append(errs, fmt.Errorf("HEALTH_CANARY_IP must be an IP address, got %q", cfg.CanaryIp))
+	} else if !cfg.familyEnabled(canary.To4() == nil) {
+		errs = append(errs, fmt.Errorf("HEALTH_CANARY_IP %s is in a disabled address family", cfg.CanaryIp))
+	}
+	for _, base := range []struct{ key, value string }{{"GITHUB_API_BASE", cfg.GithubAPIBase}, {"GITHUB_RAW_BASE", cfg.GithubRawBase}, {"DATA_ARCHIVE_URL", cfg.DataArchiveURL}} {
+		if u, err := url.Parse(base.value); base.value != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+			errs = append(errs, fmt.Errorf("%s must be an http or https URL, got %q", base.key, base.value))
+		}
+	}
+	if cfg.GithubRawBase == "" {
+		errs = append(errs, errors.New("GITHUB_RAW_BASE is required when GITHUB_API_BASE is neither api.github.com nor a GitHub Enterprise /api/v3 URL"))
+	}
+	if cfg.FallbackURL != "" {
+		if !strings.Contains(cfg.FallbackURL, "{ip}") {
+			errs = append(errs, errors.New("FALLBACK_URL must contain an {ip} placeholder"))
+		}
+		if cfg.FallbackTimeout <= 0 {
+			errs = append(errs, errors.New("FALLBACK_TIMEOUT must be positive"))
+		}
+	}
+	if cfg.RedisURL != "" {
+		if _, err := parseRedisURL(cfg.RedisURL); err != nil {
+			errs = append(errs, fmt.Errorf("REDIS_URL: %w", err))
+		}
+		if cfg.RedisTTL <= 0 {
+			errs = append(errs, errors.New("REDIS_TTL must be positive"))
+		}
+		if cfg.RedisTimeout <= 0 {
+			errs = append(errs, errors.New("REDIS_TIMEOUT must be positive"))
+		}
+	}
+	if cfg.LogMisses {
+		if cfg.LogMissesSample <= 0 {
+			errs = append(errs, errors.New("LOG_MISSES_SAMPLE must be positive"))
+		}
+		if cfg.LogMissesMaxPrefixes <= 0 {
+			errs = append(errs, errors.New("LOG_MISSES_MAX_PREFIXES must be positive"))
+		}
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be text or json, got %q", cfg.LogFormat))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		errs = append(errs, errors.New("MAX_HEADER_BYTES must be positive"))
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("SHUTDOWN_TIMEOUT must be positive"))
+	}
+	if cfg.TrustedProxyHops < 0 {
+		errs = append(errs, errors.New("TRUSTED_PROXY_HOPS must not be negative"))
+	}
+	if cfg.MaxConnections < 0 {
+		errs = append(errs, errors.New("MAX_CONNECTIONS must not be negative"))
+	}
+	if cfg.RequestTimeout < 0 {
+		errs = append(errs, errors.New("REQUEST_TIMEOUT must not be negative"))
+	}
+	if cfg.MaxConcurrent < 0 {
+		errs = append(errs, errors.New("MAX_CONCURRENT must not be negative"))
+	}
+	if cfg.MaxUploadBytes <= 0 {
+		errs = append(errs, errors.New("MAX_UPLOAD_BYTES must be positive"))
+	}
+	if cfg.MaxIpv6SpanBits < 0 || cfg.MaxIpv6SpanBits > 128 {
+		errs = append(errs, errors.New("MAX_IPV6_SPAN_BITS must be between 0 and 128"))
+	}
+	if cfg.MaxBatch <= 0 {
+		errs = append(errs, errors.New("MAX_BATCH must be positive"))
+	}
+	if cfg.BatchHostnames && cfg.DNSTimeout <= 0 {
+		errs = append(errs, errors.New("DNS_TIMEOUT must be positive"))
+	}
+	if cfg.BatchHostnames && cfg.MaxBatchHostnames <= 0 {
+		errs = append(errs, errors.New("MAX_BATCH_HOSTNAMES must be positive"))
+	}
+	if cfg.MaxCidrSegments <= 0 {
+		errs = append(errs, errors.New("MAX_CIDR_SEGMENTS must be positive"))
+	}
+	if cfg.MaxResultRows <= 0 {
+		errs = append(errs, errors.New("MAX_RESULT_ROWS must be positive"))
+	}
+	for endpoint, rows := range cfg.MaxResultRowsByEndpoint {
+		if !slices.Contains(pagedEndpoints, endpoint) {
+			errs = append(errs, fmt.Errorf("MAX_RESULT_ROWS_BY_ENDPOINT: unknown endpoint %q, must be one of %s", endpoint, strings.Join(pagedEndpoints, ", ")))
+		}
+		if n, err := strconv.Atoi(rows); err != nil || n <= 0 {
+			errs = append(errs, fmt.Errorf("MAX_RESULT_ROWS_BY_ENDPOINT: limit for %q must be a positive integer, got %q", endpoint, rows))
+		}
+	}
+	if cfg.RequiredHeader != "" && !httpguts.ValidHeaderFieldName(cfg.RequiredHeader) {
+		errs = append(errs, fmt.Errorf("REQUIRED_HEADER %q isn't a valid header name", cfg.RequiredHeader))
+	}
+	if cfg.RequiredHeaderValue != "" && cfg.RequiredHeader == "" {
+		errs = append(errs, errors.New("REQUIRED_HEADER_VALUE needs REQUIRED_HEADER"))
+	}
+	return errors.Join(errs...)
+}
+
+// pagedEndpoints are the endpoints whose results MAX_RESULT_ROWS caps
+var pagedEndpoints = []string{"rangeInfo", "getCidrInfo", "diff"}
+
+// maxResultRows returns how many rows endpoint returns per page
+func (cfg *Config) maxResultRows(endpoint string) int {
+	if n, err := strconv.Atoi(cfg.MaxResultRowsByEndpoint[endpoint]); err == nil {
+		return n
+	}
+	return cfg.MaxResultRows
+}
+
+// familyEnabled reports whether IPv6 (v6) or IPv4 (!v6) data is served
+func (cfg *Config) familyEnabled(v6 bool) bool {
+	if v6 {
+		return cfg.EnableIpv6
+	}
+	return cfg.EnableIpv4
+}
+
+// fileEnabled reports whether fi is loaded, being of an enabled family and
+// source
+func (cfg *Config) fileEnabled(fi *fileInfo) bool {
+	return cfg.familyEnabled(fi.IpV6) && !cfg.DisabledSources[fi.Source()]
+}
+
+// csvFormat returns how fi is laid out
+func (cfg *Config) csvFormat(fi *fileInfo) csvFormat {
+	source := fi.Source()
+	format := defaultCsvFormat
+	if fi.IpV6 {
+		format.bits = 128
+	}
+	if rangeFormat := cfg.RangeFormat[source]; rangeFormat != "" {
+		format.rangeFormat = rangeFormat
+	}
+	switch cfg.NumberBase[source] {
+	case "16":
+		format.base = 16
+	case "auto":
+		format.base = 0
+	}
+	// Malformed mappings are rejected at startup
+	if idx, err := parseColumns(cfg.Columns[source]); err == nil {
+		format.startCol, format.endCol, format.col = idx[0], idx[1], idx[2]
+	}
+	if idx, err := parseAddrColumns(cfg.AddrColumns[source]); err == nil {
+		format.addrStartCol, format.addrEndCol = idx[0], idx[1]
+	}
+	if comma, err := parseCsvChar(cfg.Delimiter[source]); err == nil {
+		format.comma = comma
+	}
+	if comment, err := parseCsvChar(cfg.Comment[source]); err == nil {
+		format.comment = comment
+	}
+	return format
+}
+
+// csvCharNames are the names accepted for characters that are awkward to
+// write in a comma-separated env var
+var csvCharNames = map[string]rune{"comma": ',', "semicolon": ';', "tab": '\t', "pipe": '|', "space": ' ', "hash": '#'}
+
+// parseCsvChar parses a delimiter or comment character, given as itself or
+// by name
+func parseCsvChar(raw string) (rune, error) {
+	if r, ok := csvCharNames[strings.ToLower(raw)]; ok {
+		return r, nil
+	}
+	runes := []rune(raw)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return 0, fmt.Errorf("must be a single character or one of comma, semicolon, tab, pipe, space, hash; got %q", raw)
+	}
+	return runes[0], nil
+}
+
+// parseColumns parses a start:end:country list of column indexes
+func parseColumns(raw string) ([3]int, error) {
+	var idx [3]int
+	return idx, parseColumnIndexes(raw, "start:end:country", idx[:])
+}
+
+// parseAddrColumns parses a start:end pair of column indexes
+func parseAddrColumns(raw string) ([2]int, error) {
+	var idx [2]int
+	return idx, parseColumnIndexes(raw, "start:end", idx[:])
+}
+
+// parseColumnIndexes parses raw, a colon-separated list laid out as shape,
+// into idx, which has one slot per index
+func parseColumnIndexes(raw, shape string, idx []int) error {
+	parts := strings.Split(raw, ":")
+	if len(parts) != len(idx) {
+		return fmt.Errorf("columns must be %s, got %q", shape, raw)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid column index %q", p)
+		}
+		if slices.Contains(idx[:i], n) {
+			return fmt.Errorf("columns must be distinct, got %q", raw)
+		}
+		idx[i] = n
+	}
+	return nil
+}
+
+// families lists the enabled address families
+func (cfg *Config) families() []string {
+	var fams []string
+	if cfg.EnableIpv4 {
+		fams = append(fams, "ipv4")
+	}
+	if cfg.EnableIpv6 {
+		fams = append(fams, "ipv6")
+	}
+	return fams
+}
+
+// features lists the optional features that are turned on, for the startup
+// summary
+func (cfg *Config) features() []string {
+	features := []string{}
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"auto_update", cfg.AutoUpdate},
+		{"auto_update_dry_run", cfg.AutoUpdateDryRun},
+		{"scheduled_updates", cfg.AutoUpdateInterval > 0},
+		{"startup_gate", cfg.StartupGate},
+		{"rir", cfg.RirCsv != ""},
+		{"city", cfg.CityCsv != ""},
+		{"fallback", cfg.FallbackURL != ""},
+		{"redis_cache", cfg.RedisURL != ""},
+		{"enrichers", len(cfg.Enrichers) > 0},
+		{"log_misses", cfg.LogMisses},
+		{"anonymize_ips", cfg.AnonymizeIPs},
+		{"metrics", cfg.MetricsEnabled},
+		{"admin", cfg.AdminToken != ""},
+		{"required_header", cfg.RequiredHeader != ""},
+		{"dev_endpoints", cfg.DevEndpoints},
+		{"tls", cfg.TLSCertFile != ""},
+		{"batch_hostnames", cfg.BatchHostnames},
+		{"request_timeout", cfg.RequestTimeout > 0},
+		{"max_concurrent", cfg.MaxConcurrent > 0},
+		{"max_connections", cfg.MaxConnections > 0},
+	} {
+		if f.on {
+			features = append(features, f.name)
+		}
+	}
+	return features
+}
+
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// knownSource reports whether name is the source of a configured file
+func (cfg *Config) knownSource(name string) bool {
+	for i := range cfg.Files {
+		if cfg.Files[i].Source() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// envReader parses typed env vars, collecting every malformed value so
+// they can all be reported at once. Keys missing from the environment are
+// looked up in file, the config file's values.
+type envReader struct {
+	errs     []error
+	file     map[string]string
+	override map[string]string
+	seen     map[string]bool
+}
+
+func (e *envReader) lookup(key string) (string, bool) {
+	if e.seen == nil {
+		e.seen = map[string]bool{}
+	}
+	e.seen[key] = true
+	if v, ok := e.override[key]; ok {
+		return strings.TrimSpace(v), strings.TrimSpace(v) != ""
+	}
+	v, ok := os.LookupEnv(key)
+	v = strings.TrimSpace(v)
+	if ok && v != "" {
+		return v, true
+	}
+	v = strings.TrimSpace(e.file[key])
+	return v, v != ""
+}
+
+// checkFileKeys reports config file keys that no setting read, which are
+// most likely typos
+func (e *envReader) checkFileKeys(path string) {
+	var unknown []string
+	for key := range e.file {
+		if !e.seen[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		e.errs = append(e.errs, fmt.Errorf("%s: unknown setting %q", path, key))
+	}
+}
+
+// checkOverrideKeys reports overrides that no setting read
+func (e *envReader) checkOverrideKeys() {
+	var unknown []string
+	for key := range e.override {
+		if !e.seen[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		e.errs = append(e.errs, fmt.Errorf("unknown setting %q", key))
+	}
+}
+
+func (e *envReader) string(key, def string) string {
+	if v, ok := e.lookup(key); ok {
+		return v
+	}
+	return def
+}
+
+// set reads key as a comma-separated list of names
+func (e *envReader) set(key string) map[string]bool {
+	v, ok := e.lookup(key)
+	if !ok {
+		return nil
+	}
+	m := map[string]bool{}
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			m[name] = true
+		}
+	}
+	return m
+}
+
+// list reads key as a comma-separated list, keeping its order
+func (e *envReader) list(key string) []string {
+	var items []string
+	for _, item := range strings.Split(e.string(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// stringMap reads key as comma-separated name=value pairs
+func (e *envReader) stringMap(key string) map[string]string {
+	v, ok := e.lookup(key)
+	if !ok {
+		return nil
+	}
+	m := map[string]string{}
+	for _, pair := range strings.Split(v, ",") {
+		name, value, found := strings.Cut(pair, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !found || name == "" || value == "" {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid name=value pair %q", key, pair))
+			continue
+		}
+		m[name] = value
+	}
+	return m
+}
+
+func (e *envReader) bool(key string, def bool) bool {
+	v, ok := e.lookup(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid boolean %q", key, v))
+		return def
+	}
+	return b
+}
+
+func (e *envReader) int(key string, def int) int {
+	v, ok := e.lookup(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid integer %q", key, v))
+		return def
+	}
+	return n
+}
+
+func (e *envReader) float(key string, def float64) float64 {
+	v, ok := e.lookup(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid number %q", key, v))
+		return def
+	}
+	return f
+}
+
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+	v, ok := e.lookup(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid duration %q", key, v))
+		return def
+	}
+	return d
+}
+
+func (e *envReader) level(key string, def slog.Level) slog.Level {
+	v, ok := e.lookup(key)
+	if !ok {
+		return def
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(v)); err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid log level %q", key, v))
+		return def
+	}
+	return level
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is a YAML config file. Settings are keyed by their env var
+// names, and lists and maps are accepted wherever the env var takes a
+// comma-separated value. FILES, which has no env var, replaces the data
+// file list.
+type configFile struct {
+	// values holds every setting rendered the way its env var would be
+	values map[string]string
+	files  []fileInfo
+}
+
+type configFileEntry struct {
+	RemotePath string `yaml:"remote_path"`
+	LocalName  string `yaml:"local_name"`
+	IpV6       bool   `yaml:"ipv6"`
+}
+
+// readConfigFile parses the YAML config file at path
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	cf := &configFile{values: map[string]string{}}
+	var errs []error
+	for key, node := range raw {
+		key = strings.ToUpper(key)
+		if key == "FILES" {
+			var entries []configFileEntry
+			if err := node.Decode(&entries); err != nil {
+				errs = append(errs, fmt.Errorf("%s: FILES: %w", path, err))
+				continue
+			}
+			for _, e := range entries {
+				if e.RemotePath == "" || e.LocalName == "" {
+					errs = append(errs, fmt.Errorf("%s: FILES entries need remote_path and local_name", path))
+					continue
+				}
+				cf.files = append(cf.files, fileInfo{e.RemotePath, e.LocalName, e.IpV6})
+			}
+			continue
+		}
+		value, err := configFileValue(&node)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+			continue
+		}
+		cf.values[key] = value
+	}
+	return cf, errors.Join(errs...)
+}
+
+// configFileValue renders a scalar as is, a list as comma-separated items
+// and a map as comma-separated name=value pairs
+func configFileValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, nil
+	case yaml.SequenceNode:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return "", err
+		}
+		return strings.Join(items, ","), nil
+	case yaml.MappingNode:
+		var m map[string]string
+		if err := node.Decode(&m); err != nil {
+			return "", err
+		}
+		pairs := make([]string, 0, len(m))
+		for k, v := range m {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return "", errors.New("must be a scalar, list or map")
+	}
+}
+
+// redacted returns a copy of cfg that is safe to log
+func (cfg Config) redacted() Config {
+	for _, secret := range []*string{&cfg.AdminToken, &cfg.FallbackKey, &cfg.RequiredHeaderValue} {
+		if *secret != "" {
+			*secret = "[redacted]"
+		}
+	}
+	if u, err := url.Parse(cfg.RedisURL); err == nil {
+		cfg.RedisURL = u.Redacted()
+	}
+	cfg.outbound = nil
+	return cfg
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// continents are the UN M.49 regions coverage is grouped by
+var continents = []language.Region{
+	language.MustParseRegion("002"), // Africa
+	language.MustParseRegion("019"), // Americas
+	language.MustParseRegion("142"), // Asia
+	language.MustParseRegion("150"), // Europe
+	language.MustParseRegion("009"), // Oceania
+}
+
+var continentNames = display.Regions(language.English)
+
+// continentIndex returns the index in continents of the one country is in,
+// or -1 for codes that aren't a country of any, such as EU or ZZ
+func continentIndex(country string) int {
+	region, err := language.ParseRegion(country)
+	if err != nil {
+		return -1
+	}
+	for i, continent := range continents {
+		if continent.Contains(region) {
+			return i
+		}
+	}
+	return -1
+}
+
+// familyCoverage is how much of one family's address space the data covers.
+// Counts are strings since IPv6 ones overflow JSON numbers.
+type familyCoverage struct {
+	Total    string  `json:"total"`
+	Covered  string  `json:"covered"`
+	Fraction float64 `json:"fraction"`
+}
+
+// regionCoverage is the number of addresses of each family that resolve to
+// a country, or to any country of a continent
+type regionCoverage struct {
+	Code string `json:"code"`
+	Name string `json:"name,omitempty"`
+	Ipv4 string `json:"ipv4"`
+	Ipv6 string `json:"ipv6"`
+}
+
+type coverageStats struct {
+	Ipv4       familyCoverage   `json:"ipv4"`
+	Ipv6       familyCoverage   `json:"ipv6"`
+	Continents []regionCoverage `json:"continents"`
+	Countries  []regionCoverage `json:"countries"`
+}
+
+// computeCoverage counts the addresses arr resolves in each family and to
+// each country. Overlapping ranges are counted once, for the country lookups
+// answer with. The IPv6 space excludes the numbers IPv4 ranges take up.
+func computeCoverage(arr []IpAddressRange) coverageStats {
+	counts := map[string]*[2]big.Int{}
+	var covered [2]big.Int
+	size := new(big.Int)
+	count := func(family int) func(start, end *big.Int, country *string) bool {
+		return func(start, end *big.Int, country *string) bool {
+			if country == nil {
+				return true
+			}
+			size.Sub(end, start).Add(size, one)
+			covered[family].Add(&covered[family], size)
+			if counts[*country] == nil {
+				counts[*country] = new([2]big.Int)
+			}
+			counts[*country][family].Add(&counts[*country][family], size)
+			return true
+		}
+	}
+	ctx := context.Background()
+	eachSegment(ctx, arr, new(big.Int), maxIpv4Num, count(0))
+	eachSegment(ctx, arr, new(big.Int).Add(maxIpv4Num, one), maxIpv6Num, count(1))
+
+	stats := coverageStats{
+		Ipv4:       newFamilyCoverage(&covered[0], new(big.Int).Add(maxIpv4Num, one)),
+		Ipv6:       newFamilyCoverage(&covered[1], new(big.Int).Sub(maxIpv6Num, maxIpv4Num)),
+		Continents: []regionCoverage{},
+		Countries:  []regionCoverage{},
+	}
+	byContinent := make([][2]big.Int, len(continents))
+	for country, n := range counts {
+		stats.Countries = append(stats.Countries, regionCoverage{Code: country, Ipv4: n[0].String(), Ipv6: n[1].String()})
+		if i := continentIndex(country); i >= 0 {
+			byContinent[i][0].Add(&byContinent[i][0], &n[0])
+			byContinent[i][1].Add(&byContinent[i][1], &n[1])
+		}
+	}
+	sort.Slice(stats.Countries, func(i, j int) bool { return stats.Countries[i].Code < stats.Countries[j].Code })
+	for i, continent := range continents {
+		stats.Continents = append(stats.Continents, regionCoverage{
+			Code: continent.String(),
+			Name: continentNames.Name(continent),
+			Ipv4: byContinent[i][0].String(),
+			Ipv6: byContinent[i][1].String(),
+		})
+	}
+	return stats
+}
+
+func newFamilyCoverage(covered, total *big.Int) familyCoverage {
+	fraction, _ := new(big.Rat).SetFrac(covered, total).Float64()
+	return familyCoverage{Total: total.String(), Covered: covered.String(), Fraction: fraction}
+}
+
+// coverage reports how much of the address space the loaded data covers,
+// overall and per continent and country. It is computed once per load.
+func (a *app) coverage(c *gin.Context) {
+	renderJSON(c, http.StatusOK, gin.H{"ok": true, "coverage": a.current().coverage})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dataset is one loaded generation of the range data, together with the
+// config it was loaded with, which later reloads reuse. Handlers read the
+// current one through app.current and never modify it, so a reload can
+// build a new dataset and swap it in atomically.
+type dataset struct {
+	cfg *Config
+	arr []IpAddressRange
+	// maxEnd is arr's runningMaxEnd, for finding overlapping ranges
+	maxEnd       []*big.Int
+	statuses     []fileStatus
+	loadDuration time.Duration
+	// updated lists the files downloaded while loading this generation
+	updated []string
+	// pendingUpdates lists files a dry-run update found changed upstream
+	pendingUpdates []string
+	// version is the short SHAs of the loaded files, sent as X-Data-Version
+	version string
+	// coverage is what /coverage reports
+	coverage coverageStats
+	// fallback is set when STARTUP_GATE timed out and this is the data on
+	// disk, loaded without an update
+	fallback bool
+	// disagreement compares the sources, nil with fewer than two
+	disagreement *disagreementStats
+	// loadedFrom is the load step this generation came from: "network"
+	// when the update step ran first, "local" when it didn't
+	loadedFrom string
+}
+
+// loadDataset runs the source's update and load steps, timing each phase
+func loadDataset(cfg *Config, src dataSource) (*dataset, error) {
+	loadStart := time.Now()
+	update, err := updateData(cfg, src)
+	if err != nil {
+		return nil, err
+	}
+	data, err := loadFromDisk(cfg, src, update, loadStart)
+	if err != nil {
+		return nil, err
+	}
+	data.loadedFrom = "network"
+	return data, nil
+}
+
+// loadSteps are the steps LOAD_CHAIN picks from: "network" updates the
+// files before loading them, as by default, and "local" loads the files
+// already on disk without touching the network
+var loadSteps = map[string]func(cfg *Config, src dataSource) (*dataset, error){
+	"network": loadDataset,
+	"local": func(cfg *Config, src dataSource) (*dataset, error) {
+		return loadFromDisk(cfg, src, updateResult{}, time.Now())
+	},
+}
+
+// loadChain runs the LOAD_CHAIN steps in turn until one loads, logging
+// which did. If none does, the errors of every step are returned.
+func loadChain(cfg *Config, src dataSource) (*dataset, error) {
+	var errs []error
+	for _, step := range cfg.LoadChain {
+		data, err := loadSteps[step](cfg, src)
+		if err == nil {
+			slog.Info("data loaded by load chain step", "step", step, "chain", cfg.LoadChain)
+			return data, nil
+		}
+		slog.Warn("load chain step failed, trying the next one", "step", step, "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", step, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// updateData runs the source's update step, fetching changed files to disk
+// without loading them
+func updateData(cfg *Config, src dataSource) (updateResult, error) {
+	start := time.Now()
+	update, err := src.Update(cfg)
+	if err != nil {
+		return update, fmt.Errorf("updating data: %w", err)
+	}
+	if len(update.Updated) > 0 {
+		slog.Info("data files updated", "files", update.Updated)
+	}
+	if len(update.Pending) > 0 {
+		slog.Info("dry run: data files have pending updates", "files", update.Pending)
+	}
+	logPhase("download", start)
+	return update, nil
+}
+
+// loadFromDisk runs the source's load step on the files already on disk.
+// update describes the update that preceded it, if any, and loadStart is
+// when loading began for the reported duration.
+func loadFromDisk(cfg *Config, src dataSource, update updateResult, loadStart time.Time) (*dataset, error) {
+	arr, statuses, err := src.Load(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading required data: %w", err)
+	}
+	// Sources agreeing on a block drop out as duplicates, so compare them
+	// first
+	disagreementStart := time.Now()
+	disagreement := computeDisagreement(arr)
+	logPhase("disagreement", disagreementStart)
+	arr = dedupeRanges(arr)
+	coverageStart := time.Now()
+	coverage := computeCoverage(arr)
+	logPhase("coverage", coverageStart)
+	loadDuration := time.Since(loadStart)
+	slog.Info("data loaded", "ranges", len(arr), "duration", loadDuration.Round(time.Millisecond))
+
+	return &dataset{
+		cfg:            cfg,
+		arr:            arr,
+		maxEnd:         runningMaxEnd(arr),
+		statuses:       statuses,
+		loadDuration:   loadDuration,
+		updated:        update.Updated,
+		pendingUpdates: update.Pending,
+		version:        dataVersion(statuses),
+		coverage:       coverage,
+		disagreement:   disagreement,
+		loadedFrom:     "local",
+	}, nil
+}
+
+// confirmedCurrent returns a copy of d for an update that downloaded no
+// file, with the modification times the update refreshed and its pending
+// dry-run updates, so freshness is tracked without loading the files again
+func (d *dataset) confirmedCurrent(update updateResult) *dataset {
+	next := *d
+	next.statuses = slices.Clone(d.statuses)
+	for i := range next.statuses {
+		st := &next.statuses[i]
+		if info, err := os.Stat(filepath.Join(d.cfg.DataDir, st.File)); err == nil && st.Loaded {
+			st.Modified = info.ModTime()
+		}
+	}
+	next.updated = nil
+	next.pendingUpdates = update.Pending
+	next.fallback = false
+	return &next
+}
+
+// dataVersion joins the short SHAs of the loaded files in file order, so it
+// changes whenever any of them does
+func dataVersion(statuses []fileStatus) string {
+	var shas []string
+	for _, st := range statuses {
+		if st.SHA != "" {
+			shas = append(shas, st.SHA[:min(len(st.SHA), 7)])
+		}
+	}
+	return strings.Join(shas, ",")
+}
+
+// current returns the dataset being served, which is nil only while
+// STARTUP_GATE waits for the first load
+func (a *app) current() *dataset {
+	return a.data.Load()
+}
+
+// statuses returns the load status of the current dataset's files, or nil
+// before the first load
+func (a *app) statuses() []fileStatus {
+	if data := a.current(); data != nil {
+		return data.statuses
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// delegatedSource serves the registries' delegated-stats files, the
+// authoritative record of which country each block was handed out to. The
+// files are maintained externally, e.g. mirrored from the RIR FTP sites.
+type delegatedSource struct{}
+
+func (delegatedSource) Update(cfg *Config) (updateResult, error) {
+	return updateResult{}, nil
+}
+
+func (delegatedSource) Load(cfg *Config) ([]IpAddressRange, []fileStatus, error) {
+	arr := []IpAddressRange{}
+	statuses := make([]fileStatus, len(cfg.DelegatedFiles))
+	var errs []error
+	parseStart := time.Now()
+
+	for i, path := range cfg.DelegatedFiles {
+		fi := &fileInfo{RemotePath: "rir-delegated/" + filepath.Base(path), LocalName: filepath.Base(path)}
+		st := &statuses[i]
+		st.File, st.Source, st.Required = fi.LocalName, fi.Source(), true
+
+		skipped, err := readDelegated(path, func(r delegatedRecord) {
+			if !cfg.familyEnabled(r.v6) {
+				return
+			}
+			arr = append(arr, IpAddressRange{r.start, r.end, r.country, fi})
+			st.Rows++
+		})
+		st.Skipped = skipped
+		if err == nil && st.Rows == 0 {
+			err = errors.New("no valid rows")
+		}
+		if skipped > 0 {
+			slog.Warn("skipped malformed rows", "file", fi.LocalName, "rows", skipped)
+		}
+		if err != nil {
+			st.Error = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		st.Loaded = true
+		if info, err := os.Stat(path); err == nil {
+			st.Modified = info.ModTime()
+		}
+	}
+	logPhase("parse", parseStart)
+
+	sortStart := time.Now()
+	sortRanges(arr)
+	logPhase("sort", sortStart)
+	return arr, statuses, errors.Join(errs...)
+}
+
+// delegatedRecord is an allocated or assigned IP block of a delegated-stats
+// file
+type delegatedRecord struct {
+	start, end *big.Int
+	country    string
+	v6         bool
+}
+
+// readDelegated calls add for every allocated or assigned IPv4 and IPv6
+// block of the delegated-stats file at path, whose lines are
+// registry|cc|type|start|value|date|status[|...]. The version header,
+// summary lines, comments, ASN records and unallocated blocks are passed
+// over; malformed IP records are skipped and counted.
+func readDelegated(path string, add func(delegatedRecord)) (skipped int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rec := strings.Split(line, "|")
+		// The version header starts with a number and summary lines have
+		// "summary" in place of the date
+		if len(rec) < 7 || (rec[2] != "ipv4" && rec[2] != "ipv6") {
+			continue
+		}
+		if status := rec[6]; status != "allocated" && status != "assigned" {
+			continue
+		}
+		r, ok := parseDelegated(rec)
+		if !ok {
+			skipped++
+			continue
+		}
+		add(r)
+	}
+	return skipped, sc.Err()
+}
+
+// parseDelegated converts a record's start address and value into a range.
+// An IPv4 value counts addresses, which needn't be a power of two, while an
+// IPv6 value is a prefix length.
+func parseDelegated(rec []string) (delegatedRecord, bool) {
+	country := strings.ToUpper(rec[1])
+	addr, err := netip.ParseAddr(rec[3])
+	if err != nil || len(country) != 2 {
+		return delegatedRecord{}, false
+	}
+	value, err := strconv.ParseUint(rec[4], 10, 64)
+	if err != nil {
+		return delegatedRecord{}, false
+	}
+
+	start := new(big.Int).SetBytes(addr.AsSlice())
+	end := new(big.Int)
+	switch {
+	case rec[2] == "ipv4" && addr.Is4():
+		if value == 0 {
+			return delegatedRecord{}, false
+		}
+		end.SetUint64(value - 1)
+		end.Add(end, start)
+		if end.Cmp(maxIpv4Num) > 0 {
+			return delegatedRecord{}, false
+		}
+	case rec[2] == "ipv6" && addr.Is6() && !addr.Is4In6():
+		bits := int(value)
+		if value > 128 || netip.PrefixFrom(addr, bits).Masked().Addr() != addr {
+			return delegatedRecord{}, false
+		}
+		end.Lsh(one, uint(128-bits))
+		end.Sub(end, one)
+		end.Add(end, start)
+	default:
+		return delegatedRecord{}, false
+	}
+	return delegatedRecord{start, end, country, rec[2] == "ipv6"}, true
+}
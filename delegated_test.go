@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseDelegated(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // "start-end:country", "" when rejected
+	}{
+		{line: "apnic|AU|ipv4|1.0.0.0|256|20110811|assigned", want: "16777216-16777471:AU"},
+		// IPv4 counts needn't be a power of two
+		{line: "apnic|cn|ipv4|1.0.1.0|768|20110414|allocated", want: "16777472-16778239:CN"},
+		{line: "apnic|JP|ipv6|2001:200::|35|19990813|allocated", want: "42540528726795050063891204319802818560-42540528736698570378174246518995812351:JP"},
+		{line: "ripencc|DE|ipv6|2001:db8::|128|20200101|assigned", want: "42540766411282592856903984951653826560-42540766411282592856903984951653826560:DE"},
+		{line: "apnic|AU|ipv4|1.0.0.0|0|20110811|assigned"},
+		{line: "apnic|AU|ipv4|255.255.255.0|512|20110811|assigned"},
+		{line: "apnic|AU|ipv4|1.0.0.x|256|20110811|assigned"},
+		{line: "apnic|AU|ipv4|2001:200::|256|20110811|assigned"},
+		{line: "apnic|AUS|ipv4|1.0.0.0|256|20110811|assigned"},
+		{line: "apnic|JP|ipv6|2001:200::1|35|19990813|allocated"},
+		{line: "apnic|JP|ipv6|2001:200::|129|19990813|allocated"},
+		{line: "apnic|JP|ipv6|::ffff:1.0.0.0|120|19990813|allocated"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if r, ok := parseDelegated(strings.Split(tt.line, "|")); ok {
+			got = fmt.Sprintf("%s-%s:%s", r.start, r.end, r.country)
+		}
+		if got != tt.want {
+			t.Errorf("parseDelegated(%s) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestReadDelegated(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "delegated-apnic-latest", strings.Join([]string{
+		"2|apnic|20240101|3|19830613|20231231|+1000",
+		"# a comment",
+		"apnic|*|asn|*|1|summary",
+		"apnic|*|ipv4|*|2|summary",
+		"apnic|*|ipv6|*|1|summary",
+		"apnic|AU|ipv4|1.0.0.0|256|20110811|assigned",
+		"apnic|JP|asn|173|1|20020801|allocated",
+		"apnic|CN|ipv4|1.0.1.0|768|20110414|allocated",
+		"apnic|AU|ipv4|1.0.4.0|x|20110412|allocated",
+		"apnic||ipv4|1.0.8.0|256|20110412|available",
+		"apnic|JP|ipv6|2001:200::|35|19990813|allocated",
+		"",
+	}, "\n"))
+	var got []string
+	skipped, err := readDelegated(path, func(r delegatedRecord) {
+		got = append(got, fmt.Sprintf("%s-%s:%s:v6=%v", r.start, r.end, r.country, r.v6))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"16777216-16777471:AU:v6=false",
+		"16777472-16778239:CN:v6=false",
+		"42540528726795050063891204319802818560-42540528736698570378174246518995812351:JP:v6=true",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+	if skipped != 1 {
+		t.Errorf("skipped = %d, want 1 for the malformed count", skipped)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// randomPublicIp returns a random address that isn't in a reserved range.
+// IPv6 addresses are drawn from the 2000::/3 global unicast block.
+func randomPublicIp(v6 bool) net.IP {
+	for {
+		var addr net.IP
+		if v6 {
+			addr = make(net.IP, net.IPv6len)
+			for i := range addr {
+				addr[i] = byte(rand.UintN(256))
+			}
+			addr[0] = 0x20 | addr[0]&0x1f
+		} else {
+			n := rand.Uint32()
+			addr = net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		}
+		if reservedCategory(addr) == "" {
+			return addr
+		}
+	}
+}
+
+// randomIp looks up a random public IPv4, or IPv6 with ?family=v6. It is
+// only registered when DEV_ENDPOINTS is enabled.
+func (a *app) randomIp(c *gin.Context) {
+	raw := randomPublicIp(c.Query("family") == "v6").String()
+	resp := a.resolveVerbose(c, a.current(), raw)
+	// Misses don't echo the address, but here it's the whole point
+	if resp.IpAddr == nil {
+		resp.IpAddress = *parseIpAddress(raw)
+	}
+	renderJSON(c, http.StatusOK, resp)
+}
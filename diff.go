@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rangeChange is one range that differs between the live and the candidate
+// data. Ranges are identified by their start and end, so a range that was
+// split or resized shows up as removed and added.
+type rangeChange struct {
+	Change string `json:"change"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	// From is the live country and To the candidate's; an added range has
+	// only To and a removed one only From
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// countryReassignment counts changed ranges that moved between two countries
+type countryReassignment struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Ranges int    `json:"ranges"`
+}
+
+type datasetDiff struct {
+	LiveRanges      int                   `json:"live_ranges"`
+	CandidateRanges int                   `json:"candidate_ranges"`
+	Added           int                   `json:"added"`
+	Removed         int                   `json:"removed"`
+	Changed         int                   `json:"changed"`
+	Unchanged       int                   `json:"unchanged"`
+	Reassignments   []countryReassignment `json:"reassignments"`
+	Details         []rangeChange         `json:"details,omitempty"`
+	// Truncated is set when there were more changes than Details lists,
+	// and Next links to the rest
+	Truncated bool   `json:"truncated,omitempty"`
+	Next      string `json:"next,omitempty"`
+}
+
+// diffDatasets compares the sorted live and cand range lists by start and
+// end. A range listed several times, with different countries, counts once,
+// with the country lookups would answer. Changes are only listed in
+// Details when limit is positive, at most limit of them after skipping the
+// first offset.
+func diffDatasets(live, cand []IpAddressRange, offset, limit int) datasetDiff {
+	diff := datasetDiff{LiveRanges: len(live), CandidateRanges: len(cand), Reassignments: []countryReassignment{}}
+	reassigned := map[[2]string]int{}
+	changes := 0
+	record := func(change string, r *IpAddressRange, from, to string) {
+		if changes++; limit <= 0 || changes <= offset {
+			return
+		}
+		if len(diff.Details) == limit {
+			diff.Truncated = true
+			return
+		}
+		v6 := r.end.Cmp(maxIpv4Num) > 0
+		diff.Details = append(diff.Details, rangeChange{change, numToIp(r.start, v6).String(), numToIp(r.end, v6).String(), from, to})
+	}
+
+	i, j := 0, 0
+	for i < len(live) || j < len(cand) {
+		c := 0
+		switch {
+		case i == len(live):
+			c = 1
+		case j == len(cand):
+			c = -1
+		default:
+			c = compareBounds(&live[i], &cand[j])
+		}
+		switch {
+		case c < 0:
+			i = lastWithBounds(live, i)
+			diff.Removed++
+			record("removed", &live[i], live[i].country, "")
+			i++
+		case c > 0:
+			j = lastWithBounds(cand, j)
+			diff.Added++
+			record("added", &cand[j], "", cand[j].country)
+			j++
+		default:
+			i, j = lastWithBounds(live, i), lastWithBounds(cand, j)
+			if from, to := live[i].country, cand[j].country; from == to {
+				diff.Unchanged++
+			} else {
+				diff.Changed++
+				reassigned[[2]string{from, to}]++
+				record("changed", &live[i], from, to)
+			}
+			i++
+			j++
+		}
+	}
+
+	for pair, n := range reassigned {
+		diff.Reassignments = append(diff.Reassignments, countryReassignment{pair[0], pair[1], n})
+	}
+	sort.Slice(diff.Reassignments, func(a, b int) bool {
+		ra, rb := diff.Reassignments[a], diff.Reassignments[b]
+		if ra.Ranges != rb.Ranges {
+			return ra.Ranges > rb.Ranges
+		}
+		if ra.From != rb.From {
+			return ra.From < rb.From
+		}
+		return ra.To < rb.To
+	})
+	return diff
+}
+
+// compareBounds orders ranges by start, then end, as sortRanges does
+func compareBounds(a, b *IpAddressRange) int {
+	if c := a.start.Cmp(b.start); c != 0 {
+		return c
+	}
+	return a.end.Cmp(b.end)
+}
+
+// lastWithBounds returns the index of the last range of the sorted arr with
+// the same start and end as arr[i], which is the one findRange answers with
+func lastWithBounds(arr []IpAddressRange, i int) int {
+	for i+1 < len(arr) && compareBounds(&arr[i], &arr[i+1]) == 0 {
+		i++
+	}
+	return i
+}
+
+// adminDiff compares the live data with the candidate staged by
+// /admin/candidate, listing up to MAX_RESULT_ROWS changed ranges with
+// ?detail=1, after skipping the first ?offset=
+func (a *app) adminDiff(c *gin.Context) {
+	limit, offset := 0, 0
+	if c.Query("detail") == "1" {
+		limit = a.cfg.maxResultRows("diff")
+		if raw := c.Query("offset"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				respondError(c, http.StatusBadRequest, codeInvalidRequest, "offset must be a non-negative integer")
+				return
+			}
+			offset = n
+		}
+	}
+	a.candidateMu.Lock()
+	cand := a.candidate
+	a.candidateMu.Unlock()
+	if cand == nil {
+		respondError(c, http.StatusNotFound, codeNoCandidate, "no candidate loaded, stage one with POST /admin/candidate")
+		return
+	}
+	diff := diffDatasets(a.current().arr, cand.data.arr, offset, limit)
+	if diff.Truncated {
+		diff.Next = nextPage(c, "offset", strconv.Itoa(offset+limit))
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "diff": diff})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log/slog"
+	"math/big"
+	"slices"
+)
+
+// disagreementStats measures how far the sources disagree where they
+// overlap: overlap counts the addresses covered by at least two sources and
+// disagreeing those of them the sources give different countries for.
+// Counts are strings since IPv6 ones overflow JSON numbers.
+type disagreementStats struct {
+	Overlap     string                   `json:"overlap"`
+	Disagreeing string                   `json:"disagreeing"`
+	Rate        float64                  `json:"rate"`
+	Pairs       []sourcePairDisagreement `json:"pairs"`
+}
+
+// sourcePairDisagreement is disagreementStats for one pair of sources
+type sourcePairDisagreement struct {
+	Sources     [2]string `json:"sources"`
+	Overlap     string    `json:"overlap"`
+	Disagreeing string    `json:"disagreeing"`
+	Rate        float64   `json:"rate"`
+}
+
+type overlapTally struct {
+	overlap, disagreeing big.Int
+}
+
+func (t *overlapTally) add(size *big.Int, disagree bool) {
+	t.overlap.Add(&t.overlap, size)
+	if disagree {
+		t.disagreeing.Add(&t.disagreeing, size)
+	}
+}
+
+func (t *overlapTally) rate() float64 {
+	if t.overlap.Sign() == 0 {
+		return 0
+	}
+	rate, _ := new(big.Rat).SetFrac(&t.disagreeing, &t.overlap).Float64()
+	return rate
+}
+
+// computeDisagreement overlays the sources of the sorted arr, duplicates
+// included, and tallies where they overlap and disagree, overall and for
+// every pair of sources. It returns nil when arr has fewer than two sources.
+// Sources are compared on every country they give an address, so one whose
+// own ranges overlap disagrees unless the other gives the same countries.
+func computeDisagreement(arr []IpAddressRange) *disagreementStats {
+	var sources []string
+	// sourceIndex maps each file to its source's index in sources
+	sourceIndex := map[*fileInfo]int{}
+	for i := range arr {
+		fi := arr[i].source
+		if _, ok := sourceIndex[fi]; ok {
+			continue
+		}
+		if sourceIndex[fi] = slices.Index(sources, fi.Source()); sourceIndex[fi] < 0 {
+			sourceIndex[fi] = len(sources)
+			sources = append(sources, fi.Source())
+		}
+	}
+	if len(sources) < 2 {
+		return nil
+	}
+
+	var total overlapTally
+	pairs := make([][]overlapTally, len(sources))
+	for i := range pairs {
+		pairs[i] = make([]overlapTally, len(sources))
+	}
+	// countries holds, per source index, the countries of the active
+	// ranges from that source
+	countries := make([][]string, len(sources))
+
+	// Sweep the address space in segments over which the set of ranges
+	// containing every address stays the same
+	var active []*IpAddressRange
+	pos, segEnd, size := new(big.Int), new(big.Int), new(big.Int)
+	for next := 0; next < len(arr) || len(active) > 0; {
+		if len(active) == 0 {
+			pos.Set(arr[next].start)
+		}
+		for next < len(arr) && arr[next].start.Cmp(pos) == 0 {
+			active = append(active, &arr[next])
+			next++
+		}
+		segEnd.Set(active[0].end)
+		for _, r := range active[1:] {
+			if r.end.Cmp(segEnd) < 0 {
+				segEnd.Set(r.end)
+			}
+		}
+		if next < len(arr) && arr[next].start.Cmp(segEnd) <= 0 {
+			segEnd.Sub(arr[next].start, one)
+		}
+
+		for i := range countries {
+			countries[i] = countries[i][:0]
+		}
+		present := 0
+		for _, r := range active {
+			i := sourceIndex[r.source]
+			if len(countries[i]) == 0 {
+				present++
+			}
+			if !slices.Contains(countries[i], r.country) {
+				countries[i] = append(countries[i], r.country)
+			}
+		}
+		if present >= 2 {
+			size.Sub(segEnd, pos).Add(size, one)
+			anyDisagree := false
+			for i := range sources {
+				for j := i + 1; j < len(sources); j++ {
+					if len(countries[i]) == 0 || len(countries[j]) == 0 {
+						continue
+					}
+					disagree := !sameCountries(countries[i], countries[j])
+					pairs[i][j].add(size, disagree)
+					anyDisagree = anyDisagree || disagree
+				}
+			}
+			total.add(size, anyDisagree)
+		}
+
+		pos.Add(segEnd, one)
+		active = slices.DeleteFunc(active, func(r *IpAddressRange) bool {
+			return r.end.Cmp(pos) < 0
+		})
+	}
+
+	stats := &disagreementStats{
+		Overlap:     total.overlap.String(),
+		Disagreeing: total.disagreeing.String(),
+		Rate:        total.rate(),
+		Pairs:       []sourcePairDisagreement{},
+	}
+	slog.Info("source disagreement", "overlap", stats.Overlap, "disagreeing", stats.Disagreeing, "rate", stats.Rate)
+	for i := range sources {
+		for j := i + 1; j < len(sources); j++ {
+			pair := sourcePairDisagreement{
+				Sources:     [2]string{sources[i], sources[j]},
+				Overlap:     pairs[i][j].overlap.String(),
+				Disagreeing: pairs[i][j].disagreeing.String(),
+				Rate:        pairs[i][j].rate(),
+			}
+			stats.Pairs = append(stats.Pairs, pair)
+			slog.Info("source pair disagreement", "sources", pair.Sources, "overlap", pair.Overlap, "disagreeing", pair.Disagreeing, "rate", pair.Rate)
+		}
+	}
+	return stats
+}
+
+// sameCountries reports whether a and b hold the same countries, in any
+// order
+func sameCountries(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, country := range a {
+		if !slices.Contains(b, country) {
+			return false
+		}
+	}
+	return true
+}
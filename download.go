@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// downloadCsvFile fetches rawURL into a temporary file next to localPath
+// and only moves it into place once it looks like the dataset meta
+// describes, so a failed or garbage download (e.g. an HTML error page)
+// never replaces a good file
+func downloadCsvFile(client *http.Client, rawURL string, meta githubContent, localPath string, format csvFormat) error {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return fmt.Errorf("downloading file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad download status: %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/html" {
+		return errors.New("download is an HTML page, not CSV")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), filepath.Base(localPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+
+	if err := validateCsvDownload(tmp.Name(), meta, format); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return fmt.Errorf("replacing local file: %w", err)
+	}
+	return nil
+}
+
+// validateCsvDownload checks the downloaded file's size and SHA against the
+// GitHub metadata and that its first row is a start,end,country row
+func validateCsvDownload(path string, meta githubContent, format csvFormat) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return errors.New("download is empty")
+	}
+	if meta.Size > 0 && info.Size() != meta.Size {
+		return fmt.Errorf("download is %d bytes, expected %d", info.Size(), meta.Size)
+	}
+	if sha, err := gitBlobSha(path); err != nil {
+		return err
+	} else if meta.SHA != "" && sha != meta.SHA {
+		return fmt.Errorf("download SHA %s doesn't match expected %s", sha, meta.SHA)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fields, err := newCsvReader(f, format).Read()
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("first row isn't a start,end,country row: %w", err)
+	}
+	if len(fields) <= format.maxCol() {
+		return fmt.Errorf("first row %q isn't a start,end,country row", fields)
+	}
+	prefix, _ := format.prefixRanges(fields)
+	if _, _, _, ok := format.bounds(fields, prefix); !ok {
+		return fmt.Errorf("first row %q isn't a start,end,country row", fields)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"slices"
+)
+
+// Enricher adds custom fields, such as threat intel or internal tags, to
+// lookup results after the country lookup. Enrichers are listed in
+// ENRICHERS and run in that order for every valid address.
+type Enricher interface {
+	// Enrich adds fields to resp, normally under resp.Extra. ip is the
+	// address looked up, unmapped for IPv4-mapped input. An error is logged
+	// and the enricher's changes to resp are dropped.
+	Enrich(ctx context.Context, ip netip.Addr, resp *ApiResponse) error
+}
+
+// enricherFactories are the enrichers ENRICHERS can name. A build adds its
+// own by calling registerEnricher from an init func in a file of its own,
+// behind a build tag if it shouldn't always be compiled in.
+var enricherFactories = map[string]func(cfg *Config) (Enricher, error){
+	"tags": newTagsEnricher,
+}
+
+func registerEnricher(name string, factory func(cfg *Config) (Enricher, error)) {
+	enricherFactories[name] = factory
+}
+
+type namedEnricher struct {
+	name string
+	Enricher
+}
+
+// newEnrichers builds the enrichers listed in ENRICHERS
+func newEnrichers(cfg *Config) ([]namedEnricher, error) {
+	var enrichers []namedEnricher
+	for _, name := range cfg.Enrichers {
+		e, err := enricherFactories[name](cfg)
+		if err != nil {
+			return nil, fmt.Errorf("enricher %s: %w", name, err)
+		}
+		enrichers = append(enrichers, namedEnricher{name, e})
+	}
+	return enrichers, nil
+}
+
+// enrich runs the enrichers on resp, each on a copy that only replaces
+// resp if it succeeded, so a failing enricher can't leave half its fields
+// behind. Invalid addresses aren't enriched.
+func (a *app) enrich(ctx context.Context, resp *ApiResponse) {
+	if len(a.enrichers) == 0 || resp.IpAddr == nil {
+		return
+	}
+	ip, err := netip.ParseAddr(*resp.IpAddr)
+	if err != nil {
+		return
+	}
+	ip = ip.Unmap()
+	for _, e := range a.enrichers {
+		enriched := *resp
+		enriched.Extra = maps.Clone(resp.Extra)
+		if err := e.Enrich(ctx, ip, &enriched); err != nil {
+			slog.Warn("enricher failed, leaving its fields out", "enricher", e.name, "err", err)
+			continue
+		}
+		*resp = enriched
+	}
+}
+
+// tagsEnricher labels addresses with the tags of the ENRICH_TAGS prefixes
+// containing them, e.g. to mark internal networks
+type tagsEnricher struct {
+	prefixes []taggedPrefix
+}
+
+type taggedPrefix struct {
+	prefix netip.Prefix
+	tag    string
+}
+
+func newTagsEnricher(cfg *Config) (Enricher, error) {
+	e := &tagsEnricher{}
+	for raw, tag := range cfg.EnrichTags {
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return nil, err
+		}
+		e.prefixes = append(e.prefixes, taggedPrefix{prefix.Masked(), tag})
+	}
+	// Most specific first, so the order of the tags doesn't depend on the
+	// map's
+	slices.SortFunc(e.prefixes, func(x, y taggedPrefix) int {
+		return cmp.Or(y.prefix.Bits()-x.prefix.Bits(), cmp.Compare(x.tag, y.tag))
+	})
+	return e, nil
+}
+
+// Enrich sets extra.tags to the tags of every prefix containing ip, most
+// specific first, leaving it out when none does
+func (e *tagsEnricher) Enrich(ctx context.Context, ip netip.Addr, resp *ApiResponse) error {
+	var tags []string
+	for _, p := range e.prefixes {
+		if p.prefix.Contains(ip) && !slices.Contains(tags, p.tag) {
+			tags = append(tags, p.tag)
+		}
+	}
+	if tags == nil {
+		return nil
+	}
+	if resp.Extra == nil {
+		resp.Extra = map[string]any{}
+	}
+	resp.Extra["tags"] = tags
+	return nil
+}
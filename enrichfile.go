@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// enrichFileOptions are the query parameters of a ?column= upload
+type enrichFileOptions struct {
+	// column is the 0-based index of the address field
+	column    int
+	delimiter rune
+	header    bool
+}
+
+func parseEnrichFileOptions(c *gin.Context) (enrichFileOptions, error) {
+	opts := enrichFileOptions{delimiter: ','}
+	column, err := strconv.Atoi(c.Query("column"))
+	if err != nil || column < 1 {
+		return opts, fmt.Errorf("column must be a positive column number, got %q", c.Query("column"))
+	}
+	opts.column = column - 1
+	if raw := c.Query("delimiter"); raw != "" {
+		if opts.delimiter, err = parseCsvChar(raw); err != nil {
+			return opts, fmt.Errorf("delimiter %w", err)
+		}
+	}
+	opts.header = c.Query("header") == "1"
+	return opts, nil
+}
+
+// field returns the address field of line, or "" when it has too few
+// fields. A space delimiter splits on runs of whitespace, as log formats
+// pad fields; any other is parsed as CSV, allowing quoted fields.
+func (opts enrichFileOptions) field(line string) string {
+	var fields []string
+	if opts.delimiter == ' ' {
+		fields = strings.Fields(line)
+	} else {
+		r := csv.NewReader(strings.NewReader(line))
+		r.Comma = opts.delimiter
+		r.LazyQuotes = true
+		r.FieldsPerRecord = -1
+		fields, _ = r.Read()
+	}
+	if opts.column >= len(fields) {
+		return ""
+	}
+	return strings.TrimSpace(fields[opts.column])
+}
+
+// enrichFile streams the uploaded file back with a country field appended
+// to every non-empty line, or a "country" name to the header line, leaving
+// the original text of each line alone. Lines are resolved a chunk at a
+// time by one worker per CPU, in order, so memory stays bounded however
+// large the file. Lines whose field isn't a matching address get an empty
+// country. ?meta=1 sends the batchMeta headers as trailers.
+func enrichFile(c *gin.Context, resolve func(string) ApiResponse, body io.Reader, opts enrichFileOptions) {
+	meta := &batchMeta{start: time.Now()}
+	if opts.delimiter == ' ' {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "text/csv")
+	}
+	if c.Query("meta") == "1" {
+		c.Header("Trailer", strings.Join(batchMetaHeaders, ", "))
+	}
+	c.Status(http.StatusOK)
+
+	out := bufio.NewWriter(c.Writer)
+	sep := string(opts.delimiter)
+	scanner := bufio.NewScanner(body)
+	lines := make([]string, 0, uploadFlushEvery)
+	countries := make([]string, uploadFlushEvery)
+	header := opts.header
+	more := true
+	for more && !requestDone(c) {
+		lines = lines[:0]
+		for len(lines) < uploadFlushEvery {
+			if more = scanner.Scan(); !more {
+				break
+			}
+			lines = append(lines, scanner.Text())
+		}
+		if header && len(lines) > 0 {
+			out.WriteString(lines[0] + sep + "country\n")
+			lines, header = lines[1:], false
+		}
+		resolveFileChunk(lines, countries, opts, resolve, meta)
+		for i, line := range lines {
+			if strings.TrimSpace(line) != "" {
+				line += sep + countries[i]
+			}
+			out.WriteString(line + "\n")
+		}
+		out.Flush()
+		c.Writer.Flush()
+	}
+	if more || scanner.Err() != nil {
+		code, message := uploadError(c, scanner.Err())
+		slog.Warn("uploaded file cut short", "code", code, "err", message, "processed", meta.processed)
+		writeUploadError(c.Writer, false, code, message)
+	}
+	meta.write(c, c.Writer.Header())
+}
+
+// resolveFileChunk sets countries[i] to the country of lines[i]'s address
+// field, "" for misses and blank lines
+func resolveFileChunk(lines, countries []string, opts enrichFileOptions, resolve func(string) ApiResponse, meta *batchMeta) {
+	var addrs []string
+	var at []int
+	for i, line := range lines {
+		countries[i] = ""
+		if strings.TrimSpace(line) != "" {
+			addrs, at = append(addrs, opts.field(line)), append(at, i)
+		}
+	}
+	results := make([]ApiResponse, len(addrs))
+	resolveChunk(addrs, results, resolve)
+	for j, resp := range results {
+		meta.add(resp)
+		if resp.Country != nil {
+			countries[at[j]] = *resp.Country
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes. Lookup outcomes are the "error" string of an ok:false
+// lookup result; the rest are the "error.code" of a failed request.
+const (
+	// Lookup outcomes, set on ApiResponse
+	codeInvalidIP = "invalid_ip"
+	codeReserved  = "reserved"
+	codeNotFound  = "not_found"
+	// codeUnresolved is set for batch hostnames that didn't resolve
+	codeUnresolved = "unresolved_hostname"
+	// codeFamilyDisabled is set when ENABLE_IPV4 or ENABLE_IPV6 turned off
+	// the address's family
+	codeFamilyDisabled = "family_disabled"
+
+	// Request-level failures, sent with a 4xx status
+	codeInvalidRequest = "invalid_request"
+	codeTooMany        = "too_many_addresses"
+	// codeTooManySegments is sent when a CIDR batch breaks down into more
+	// than MAX_CIDR_SEGMENTS segments
+	codeTooManySegments = "too_many_segments"
+	// codeTooLarge is sent with a 413 when a body exceeds MAX_UPLOAD_BYTES
+	codeTooLarge     = "too_large"
+	codeUnauthorized = "unauthorized"
+	// codeForbidden is sent with a 403 when REQUIRED_HEADER is missing
+	codeForbidden = "forbidden"
+	// codeUnknownEndpoint is sent with a 404 for paths that aren't routed
+	codeUnknownEndpoint = "unknown_endpoint"
+	// codeNoCandidate is sent with a 404 by the candidate endpoints when
+	// none is staged
+	codeNoCandidate = "no_candidate"
+	// codeOutsideTolerance is sent with a 409 when a candidate swap is
+	// refused
+	codeOutsideTolerance = "outside_tolerance"
+	// codeNoCoordinates is sent with a 404 for ?format=geojson when the
+	// matched address has no city coordinates
+	codeNoCoordinates = "no_coordinates"
+
+	// Server-side failures, sent with a 5xx status
+	codeInternal = "internal_error"
+	// codeReloadFailed and codeUpdateFailed are sent with a 500 by the
+	// admin endpoints when the data couldn't be reloaded or updated
+	codeReloadFailed = "reload_failed"
+	codeUpdateFailed = "update_failed"
+	// codeOverloaded is sent with a 503 when MAX_CONCURRENT requests are
+	// already being handled
+	codeOverloaded = "overloaded"
+	// codeUnavailable is sent with a 503 while no dataset can be served
+	codeUnavailable = "unavailable"
+	// codeTimeout is sent with a 504 when a request runs past
+	// REQUEST_TIMEOUT, and codeCanceled with a 499 when the client left
+	codeTimeout  = "timeout"
+	codeCanceled = "canceled"
+)
+
+// apiError is the "error" object of a failed request
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorResponse is the envelope of every failed request
+type errorResponse struct {
+	Ok    bool     `json:"ok"`
+	Error apiError `json:"error"`
+}
+
+func newErrorResponse(code, message string) errorResponse {
+	return errorResponse{Error: apiError{Code: code, Message: message}}
+}
+
+// respondError writes a request-level failure
+func respondError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, newErrorResponse(code, message))
+}
+
+// respondBodyError reports a request body that couldn't be read: a 413 if
+// it ran past its size limit, otherwise a 400 with message
+func respondBodyError(c *gin.Context, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(c, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	respondError(c, http.StatusBadRequest, codeInvalidRequest, message)
+}
+
+// unknownEndpoint answers requests that match no route
+func unknownEndpoint(c *gin.Context) {
+	respondError(c, http.StatusNotFound, codeUnknownEndpoint, fmt.Sprintf("no endpoint %s %s", c.Request.Method, c.Request.URL.Path))
+}
+
+// recoverPanic logs a handler's panic and answers the request with a 500.
+// gin's own log of it dumps the request headers, client addresses in
+// X-Forwarded-For included, so it is discarded for this one, which logs
+// only the client address, masked under ANONYMIZE_IPS.
+func recoverPanic(cfg *Config) gin.RecoveryFunc {
+	return func(c *gin.Context, err any) {
+		client := clientIP(cfg, c.Request)
+		if ip := net.ParseIP(client); ip != nil && cfg.AnonymizeIPs {
+			client = anonymizeIP(ip).String()
+		}
+		slog.Error("handler panicked", "method", c.Request.Method, "path", c.Request.URL.Path, "client", client, "err", err, "stack", string(debug.Stack()))
+		respondError(c, http.StatusInternalServerError, codeInternal, "internal server error")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecoverPanicMasksClient(t *testing.T) {
+	var logged bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logged, nil)))
+
+	gin.SetMode(gin.TestMode)
+	cfg := &Config{AnonymizeIPs: true, TrustedProxyHops: 1, ClientIPHeader: "X-Forwarded-For"}
+	r := gin.New()
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, recoverPanic(cfg)))
+	r.GET("/boom", func(*gin.Context) { panic("boom") })
+
+	req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.77")
+	req.Header.Set("X-Real-IP", "198.51.100.9")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), codeInternal) {
+		t.Fatalf("response = %d %s, want a 500 %s", w.Code, w.Body, codeInternal)
+	}
+	out := logged.String()
+	if !strings.Contains(out, "client=203.0.113.0") || !strings.Contains(out, "err=boom") {
+		t.Errorf("log %q lacks the masked client or the panic", out)
+	}
+	for _, addr := range []string{"203.0.113.77", "198.51.100.9"} {
+		if strings.Contains(out, addr) {
+			t.Errorf("log %q contains the unmasked address %s", out, addr)
+		}
+	}
+}
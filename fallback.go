@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fallbackCacheSize = 10000
+	// fallbackMaxFailures consecutive failures open the circuit for
+	// fallbackCooldown, during which the upstream isn't called at all
+	fallbackMaxFailures = 5
+	fallbackCooldown    = 30 * time.Second
+)
+
+// fallbackClient asks an upstream geo API about IPs missing from the local
+// data. Answers, including upstream misses, are cached for the configured
+// TTL, and a circuit breaker stops calling an upstream that keeps failing so
+// it can't slow down lookups.
+type fallbackClient struct {
+	url          string
+	key          string
+	countryField string
+	ttl          time.Duration
+	http         *http.Client
+	anonymize    bool
+
+	mu        sync.Mutex
+	cache     map[string]fallbackEntry
+	failures  int
+	openUntil time.Time
+}
+
+type fallbackEntry struct {
+	country string
+	expires time.Time
+}
+
+func newFallbackClient(cfg *Config) *fallbackClient {
+	return &fallbackClient{
+		url:          cfg.FallbackURL,
+		key:          cfg.FallbackKey,
+		countryField: cfg.FallbackCountryField,
+		ttl:          cfg.FallbackCacheTTL,
+		anonymize:    cfg.AnonymizeIPs,
+		http:         &http.Client{Transport: cfg.outbound.Transport, Timeout: cfg.FallbackTimeout},
+		cache:        map[string]fallbackEntry{},
+	}
+}
+
+// lookup returns the upstream country for addr, or "" if the upstream
+// doesn't know it, is failing or the circuit is open
+func (f *fallbackClient) lookup(addr net.IP) string {
+	key := addr.String()
+	now := time.Now()
+
+	f.mu.Lock()
+	if e, ok := f.cache[key]; ok && now.Before(e.expires) {
+		f.mu.Unlock()
+		return e.country
+	}
+	if now.Before(f.openUntil) {
+		f.mu.Unlock()
+		return ""
+	}
+	f.mu.Unlock()
+
+	country, err := f.fetch(key)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		f.failures++
+		if f.failures >= fallbackMaxFailures {
+			f.openUntil = now.Add(fallbackCooldown)
+			f.failures = 0
+			if f.anonymize {
+				// Request errors quote the URL, which holds the IP
+				err = errors.New(strings.ReplaceAll(err.Error(), key, anonymizeIP(addr).String()))
+			}
+			slog.Warn("fallback API keeps failing, pausing calls", "cooldown", fallbackCooldown, "err", err)
+		}
+		return ""
+	}
+	f.failures = 0
+	if len(f.cache) >= fallbackCacheSize {
+		f.evictExpired(now)
+	}
+	if len(f.cache) < fallbackCacheSize {
+		f.cache[key] = fallbackEntry{country, now.Add(f.ttl)}
+	}
+	return country
+}
+
+func (f *fallbackClient) evictExpired(now time.Time) {
+	for k, e := range f.cache {
+		if !now.Before(e.expires) {
+			delete(f.cache, k)
+		}
+	}
+}
+
+// fetch calls the upstream for ip. A 404 or a missing country field is an
+// upstream miss, not a failure.
+func (f *fallbackClient) fetch(ip string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.ReplaceAll(f.url, "{ip}", ip), nil)
+	if err != nil {
+		return "", err
+	}
+	if f.key != "" {
+		req.Header.Set("Authorization", "Bearer "+f.key)
+	}
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad status from fallback API: %s", resp.Status)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding fallback response: %w", err)
+	}
+	country, _ := body[f.countryField].(string)
+	return strings.ToUpper(strings.TrimSpace(country)), nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// app holds the loaded datasets and settings shared by the HTTP handlers
+type app struct {
+	cfg *Config
+	// configPath is the config file cfg was read from, for loading
+	// candidate datasets with the same settings
+	configPath string
+	data       atomic.Pointer[dataset]
+	reloads    reloader
+	// updates serializes updates, which write to the data directory
+	updates  sync.Mutex
+	rirArr   []rirRange
+	cityArr  []cityRange
+	misses   *missLogger
+	fallback *fallbackClient
+	// lookups is the REDIS_URL lookup cache, if any
+	lookups *lookupCache
+	// lookupLatency times the lookup endpoints for /metrics
+	lookupLatency *histogram
+	lookupCounts  *counterVec
+	enrichers     []namedEnricher
+	// candidate is the dataset staged by /admin/candidate, if any
+	candidateMu sync.Mutex
+	candidate   *candidate
+}
+
+// routes registers every endpoint under the configured base path. The
+// operational endpoints stay at the root instead when OpsAtRoot is set.
+// With REQUIRED_HEADER set, every endpoint but /healthz, /ready and
+// /metrics requires it.
+func (a *app) routes(r *gin.Engine) {
+	var guard []gin.HandlerFunc
+	if a.cfg.RequiredHeader != "" {
+		guard = append(guard, requireHeader(a.cfg.RequiredHeader, a.cfg.RequiredHeaderValue))
+	}
+
+	api := r.Group(a.cfg.BasePath)
+	api.Group("", guard...).GET("/validate", a.validate)
+	data := api.Group("", append(guard, a.requireData)...)
+	data.GET("/coverage", a.coverage)
+	data.GET("/attribution", a.attribution)
+	lookups := data.Group("", a.timeLookups)
+	if a.cfg.AttributionHeader {
+		lookups.Use(a.attributionHeader)
+	}
+	if a.cfg.RequestTimeout > 0 {
+		lookups.Use(requestTimeout(a.cfg.RequestTimeout))
+	}
+	lookups.GET("/getIpInfo", a.getIpInfo)
+	lookups.GET("/myip", a.myIp)
+	lookups.GET("/rangeInfo", a.rangeInfo)
+	lookups.GET("/getCidrInfo", a.getCidrInfo)
+	lookups.GET("/compare", a.compare)
+	if a.cfg.DevEndpoints {
+		lookups.GET("/randomIp", a.randomIp)
+	}
+	lookups.POST("/getIpInfoBatch", a.getIpInfoBatch)
+	lookups.POST("/getCidrInfoBatch", a.getCidrInfoBatch)
+	lookups.POST("/getIpInfoFile", ipInfoFileHandler(a.resolver, a.cfg.MaxUploadBytes))
+
+	ops := api
+	if a.cfg.OpsAtRoot {
+		ops = r.Group("/")
+	}
+	ops.GET("/healthz", a.healthz)
+	if a.cfg.MetricsEnabled {
+		ops.GET("/metrics", a.metrics)
+	}
+	ops.GET("/ready", a.ready)
+	guarded := ops.Group("", append(guard, a.requireData)...)
+	guarded.GET("/version", a.version)
+
+	// Admin endpoints only exist when a token is configured
+	if a.cfg.AdminToken != "" {
+		admin := guarded.Group("/admin", requireAdmin(a.cfg.AdminToken))
+		admin.POST("/reload", a.adminReload)
+		admin.POST("/update", a.adminUpdate)
+		admin.GET("/selftest", a.adminSelftest)
+		admin.POST("/candidate", a.adminLoadCandidate)
+		admin.GET("/candidate", a.adminCandidateReport)
+		admin.POST("/candidate/swap", a.adminSwapCandidate)
+		admin.DELETE("/candidate", a.adminDiscardCandidate)
+		admin.GET("/diff", a.adminDiff)
+		admin.POST("/sources/:source/enable", a.adminSetSource(true))
+		admin.POST("/sources/:source/disable", a.adminSetSource(false))
+	}
+}
+
+// resolve looks up rawIpAddr, recording misses and asking the fallback API
+// about them when configured. Failed lookups carry UNKNOWN_COUNTRY_CODE as
+// their country when it is set, and reserved ones are ok with RESERVED_OK,
+// so callers after a country check Reserved as well as Ok. data is the
+// dataset the request took, for all its lookups to see the same generation
+// when a reload swaps in another.
+func (a *app) resolve(data *dataset, rawIpAddr string) ApiResponse {
+	resp, addr := a.cachedLookup(data, rawIpAddr)
+	switch {
+	case addr != nil && !data.cfg.familyEnabled(addr.To4() == nil):
+		resp = ApiResponse{Ok: false, IpAddress: *parseIpAddress(rawIpAddr), Error: codeFamilyDisabled}
+	case resp.Error == codeNotFound:
+		if a.misses != nil {
+			a.misses.record(addr)
+		}
+		if a.fallback != nil {
+			if country := a.fallback.lookup(addr); country != "" {
+				source := "fallback"
+				resp = ApiResponse{Ok: true, Country: &country, IpAddress: *parseIpAddress(rawIpAddr), Source: &source}
+			}
+		}
+	}
+	a.countLookup(resp)
+	resp.Country = a.countryOrUnknown(resp.Country)
+	if resp.Reserved && a.cfg.ReservedOk {
+		resp.Ok, resp.Error = true, ""
+	}
+	return resp
+}
+
+// resolver returns resolve bound to the dataset live at the call, for the
+// handlers that pass it on taking one per request
+func (a *app) resolver() func(string) ApiResponse {
+	data := a.current()
+	return func(rawIpAddr string) ApiResponse {
+		return a.resolve(data, rawIpAddr)
+	}
+}
+
+// countryOrUnknown returns country, or UNKNOWN_COUNTRY_CODE in place of nil
+func (a *app) countryOrUnknown(country *string) *string {
+	if country == nil && a.cfg.UnknownCountry != "" {
+		return &a.cfg.UnknownCountry
+	}
+	return country
+}
+
+// resolveVerbose is resolve plus the extended fields requested by
+// ?verbose=1, ?multi=1 and, for misses, ?nearest=1. The meta timing covers
+// all of them.
+func (a *app) resolveVerbose(c *gin.Context, data *dataset, rawIpAddr string) (resp ApiResponse) {
+	if isVerbose(c) {
+		defer func(start time.Time) {
+			resp.Meta = &lookupMeta{Input: rawIpAddr, DurationUs: time.Since(start).Microseconds()}
+			if addr, err := netip.ParseAddr(rawIpAddr); err == nil {
+				normalized := addr.String()
+				resp.Meta.Normalized = &normalized
+			}
+		}(time.Now())
+	}
+	resp = a.resolve(data, rawIpAddr)
+	a.enrich(c.Request.Context(), &resp)
+	if isVerbose(c) && resp.IpAddr != nil {
+		if addr, err := netip.ParseAddr(*resp.IpAddr); err == nil && addr.Is4In6() {
+			resp.AddressFamily = &addressFamily{Presented: "ipv6", Lookup: "ipv4"}
+		}
+	}
+	if resp.Error == codeNotFound && c.Query("nearest") == "1" {
+		// Misses carry no ip_addr, so parse the input again
+		if ipAddr := parseIpAddress(rawIpAddr); ipAddr != nil {
+			addr := net.ParseIP(*ipAddr.IpAddr)
+			resp.Nearest = nearestRanges(c, data.arr, data.maxEnd, ipToNum(addr), addr.To4() == nil)
+		}
+	}
+	if !resp.Ok || resp.Reserved || resp.Source != nil {
+		return resp
+	}
+
+	addr := net.ParseIP(*resp.IpAddr)
+	ipNum := ipToNum(addr)
+	if c.Query("multi") == "1" {
+		resp.Countries = []string{}
+		for _, match := range findAllRanges(data.arr, data.maxEnd, ipNum) {
+			if !slices.Contains(resp.Countries, match.country) {
+				resp.Countries = append(resp.Countries, match.country)
+			}
+		}
+	}
+	if !isVerbose(c) {
+		return resp
+	}
+
+	resp.CountryName = countryName(countryNamer(c.GetHeader("Accept-Language")), *resp.Country)
+	if match := findRange(data.arr, ipNum); match != nil {
+		resp.Cidr = rangeToCidrs(match.start, match.end, addr.To4() == nil)
+		resp.RangeSize = newIpNumber(c, rangeSize(match), addr.To4() == nil)
+		if accuracy, ok := data.cfg.SourceAccuracy[match.source.Source()]; ok {
+			resp.Accuracy = &accuracy
+		}
+	}
+	if a.rirArr != nil {
+		if block := findRirRange(a.rirArr, ipNum); block != nil {
+			resp.Rir = &block.rir
+			if block.allocationDate != "" {
+				resp.AllocationDate = &block.allocationDate
+			}
+		}
+	}
+	return resp
+}
+
+// getIpInfo looks up a single addr, or returns an array when addr is
+// repeated (?addr=1.2.3.4&addr=5.6.7.8). ?num= looks up an IP number instead.
+func (a *app) getIpInfo(c *gin.Context) {
+	data := a.current()
+	if data.version != "" {
+		c.Header("X-Data-Version", data.version)
+	}
+	aggregate, ok := a.parseAggregate(c)
+	if !ok {
+		return
+	}
+	addrs := c.QueryArray("addr")
+	if len(addrs) == 0 && c.Query("num") != "" {
+		a.getIpInfoByNum(c, data, aggregate)
+		return
+	}
+	// A bare /getIpInfo is usually someone trying the API in a browser, so
+	// show them how to call it instead of an invalid_ip result
+	if len(addrs) == 0 || (len(addrs) == 1 && addrs[0] == "") {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf("addr is required, e.g. %s?addr=8.8.8.8, or num= for an IP number; see the README for the other options", c.Request.URL.Path))
+		return
+	}
+	if len(addrs) <= 1 {
+		results := []ApiResponse{a.resolveVerbose(c, data, c.Query("addr"))}
+		a.finishLookups(c, data, []string{c.Query("addr")}, results, aggregate)
+		if requestDone(c) {
+			respondDone(c)
+			return
+		}
+		if wantsGeoJSON(c) {
+			a.renderGeoJSON(c, results[0])
+			return
+		}
+		renderLookup(c, results[0])
+		return
+	}
+	if wantsGeoJSON(c) {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "format=geojson takes a single addr")
+		return
+	}
+
+	if len(addrs) > a.cfg.MaxBatch {
+		respondError(c, http.StatusBadRequest, codeTooMany, fmt.Sprintf("at most %d addresses per request", a.cfg.MaxBatch))
+		return
+	}
+	results := make([]ApiResponse, len(addrs))
+	for i, raw := range addrs {
+		results[i] = a.resolveVerbose(c, data, raw)
+	}
+	a.finishLookups(c, data, addrs, results, aggregate)
+	if requestDone(c) {
+		respondDone(c)
+		return
+	}
+	renderJSON(c, http.StatusOK, results)
+}
+
+// finishLookups adds the extras that take more than the range lookup: the
+// ?aggregate=N prefix answers and the ?ptr=1 reverse DNS names. inputs are
+// the addresses results were looked up from.
+func (a *app) finishLookups(c *gin.Context, data *dataset, inputs []string, results []ApiResponse, aggregate int) {
+	a.aggregateResults(c, data, inputs, results, aggregate)
+	a.lookupPtrs(c, results)
+}
+
+// getIpInfoByNum looks up the IP number ?num=, which is IPv4 unless it is
+// too large or ?family=v6 says otherwise. The response carries the address
+// form of the number, as if it had been passed as addr.
+func (a *app) getIpInfoByNum(c *gin.Context, data *dataset, aggregate int) {
+	n, ok := new(big.Int).SetString(c.Query("num"), 10)
+	if !ok || n.Sign() < 0 {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "num must be a non-negative decimal IP number")
+		return
+	}
+	var v6 bool
+	switch c.Query("family") {
+	case "":
+		v6 = n.Cmp(maxIpv4Num) > 0
+	case "v4":
+	case "v6":
+		v6 = true
+	default:
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "family must be v4 or v6")
+		return
+	}
+	if (!v6 && n.Cmp(maxIpv4Num) > 0) || n.Cmp(maxIpv6Num) > 0 {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "num is outside the address space of its family")
+		return
+	}
+	raw := numToIp(n, v6).String()
+	results := []ApiResponse{a.resolveVerbose(c, data, raw)}
+	a.finishLookups(c, data, []string{raw}, results, aggregate)
+	if requestDone(c) {
+		respondDone(c)
+		return
+	}
+	if wantsGeoJSON(c) {
+		a.renderGeoJSON(c, results[0])
+		return
+	}
+	renderLookup(c, results[0])
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestApp returns an app with the default config, set up as main does,
+// serving ranges built from testRanges specs, or nothing when there are none
+func newTestApp(t testing.TB, specs ...string) *app {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg, err := loadConfig("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &app{cfg: cfg, lookupLatency: newHistogram(lookupBuckets), lookupCounts: newCounterVec(lookupResults)}
+	if len(specs) > 0 {
+		arr := testRanges(specs...)
+		// Loaded ranges always come from a file
+		for i := range arr {
+			arr[i].source = &cfg.Files[0]
+		}
+		a.data.Store(&dataset{cfg: cfg, arr: arr, maxEnd: runningMaxEnd(arr)})
+	}
+	return a
+}
+
+// serveTest calls handler for req, returning the recorded response
+func serveTest(handler gin.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	handler(c)
+	return w
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hostnameRe matches a DNS name of dot-separated letter/digit/hyphen labels
+// with at least one dot, so single words aren't sent to the resolver
+var hostnameRe = regexp.MustCompile(`^(?i)([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.?$`)
+
+// isHostname reports whether raw should be resolved rather than looked up
+func isHostname(raw string) bool {
+	return len(raw) <= 253 && parseIpAddress(raw) == nil && hostnameRe.MatchString(raw)
+}
+
+// batchHostnames returns the distinct hostnames among inputs
+func batchHostnames(inputs []string) []string {
+	seen := map[string]bool{}
+	var hosts []string
+	for _, raw := range inputs {
+		if isHostname(raw) && !seen[raw] {
+			seen[raw] = true
+			hosts = append(hosts, raw)
+		}
+	}
+	return hosts
+}
+
+// resolveHostnames resolves hosts concurrently, each within DNSTimeout,
+// to their first address in an enabled family. Hosts that fail to resolve
+// are left out of the result.
+func (a *app) resolveHostnames(ctx context.Context, hosts []string) map[string]netip.Addr {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		addrs = map[string]netip.Addr{}
+	)
+	for _, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, a.cfg.DNSTimeout)
+			defer cancel()
+			found, err := net.DefaultResolver.LookupNetIP(ctx, "ip", strings.TrimSuffix(host, "."))
+			if err != nil {
+				return
+			}
+			for _, addr := range found {
+				if addr = addr.Unmap(); a.cfg.familyEnabled(addr.Is6()) {
+					mu.Lock()
+					addrs[host] = addr
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return addrs
+}
+
+// lookupPtrs fills in the reverse DNS name of each result's address under
+// ?ptr=1, concurrently and within DNSTimeout each. Addresses without a PTR
+// record, or whose lookup fails or times out, are left without one.
+func (a *app) lookupPtrs(c *gin.Context, results []ApiResponse) {
+	if c.Query("ptr") != "1" {
+		return
+	}
+	var wg sync.WaitGroup
+	for i := range results {
+		if results[i].IpAddr == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request.Context(), a.cfg.DNSTimeout)
+			defer cancel()
+			names, err := net.DefaultResolver.LookupAddr(ctx, *results[i].IpAddr)
+			if err != nil || len(names) == 0 {
+				return
+			}
+			ptr := strings.TrimSuffix(names[0], ".")
+			results[i].Ptr = &ptr
+		}()
+	}
+	wg.Wait()
+}
+
+// resolveBatchItem looks up one batch input, which is an IP or, when
+// hostnames were resolved into hosts, a hostname. Results for hostnames
+// carry the hostname and the address it resolved to.
+func (a *app) resolveBatchItem(c *gin.Context, data *dataset, raw string, hosts map[string]netip.Addr) ApiResponse {
+	if hosts == nil || !isHostname(raw) {
+		return a.resolveVerbose(c, data, raw)
+	}
+	addr, ok := hosts[raw]
+	if !ok {
+		return ApiResponse{Ok: false, Country: a.countryOrUnknown(nil), Hostname: &raw, Error: codeUnresolved}
+	}
+	resp := a.resolveVerbose(c, data, addr.String())
+	ip := addr.String()
+	resp.IpAddress = IpAddress{IpAddr: &ip, IpV6: addr.Is6()}
+	resp.Hostname = &raw
+	return resp
+}
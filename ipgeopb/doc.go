@@ -0,0 +1,5 @@
+// Package ipgeopb holds the Protocol Buffers types of the lookup results,
+// generated from ipgeo.proto.
+package ipgeopb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative ipgeo.proto
@@ -0,0 +1,726 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: ipgeo.proto
+
+package ipgeopb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type IpInfo struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Ok             bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Country        *string                `protobuf:"bytes,2,opt,name=country,proto3,oneof" json:"country,omitempty"`
+	IpAddr         *string                `protobuf:"bytes,3,opt,name=ip_addr,json=ipAddr,proto3,oneof" json:"ip_addr,omitempty"`
+	IpV6           bool                   `protobuf:"varint,4,opt,name=ip_v6,json=ipV6,proto3" json:"ip_v6,omitempty"`
+	Reserved       bool                   `protobuf:"varint,5,opt,name=reserved,proto3" json:"reserved,omitempty"`
+	Category       *string                `protobuf:"bytes,6,opt,name=category,proto3,oneof" json:"category,omitempty"`
+	Rir            *string                `protobuf:"bytes,7,opt,name=rir,proto3,oneof" json:"rir,omitempty"`
+	AllocationDate *string                `protobuf:"bytes,8,opt,name=allocation_date,json=allocationDate,proto3,oneof" json:"allocation_date,omitempty"`
+	CountryName    *string                `protobuf:"bytes,9,opt,name=country_name,json=countryName,proto3,oneof" json:"country_name,omitempty"`
+	Countries      []string               `protobuf:"bytes,10,rep,name=countries,proto3" json:"countries,omitempty"`
+	Cidr           []string               `protobuf:"bytes,11,rep,name=cidr,proto3" json:"cidr,omitempty"`
+	RangeSize      *string                `protobuf:"bytes,12,opt,name=range_size,json=rangeSize,proto3,oneof" json:"range_size,omitempty"`
+	Accuracy       *string                `protobuf:"bytes,13,opt,name=accuracy,proto3,oneof" json:"accuracy,omitempty"`
+	Source         *string                `protobuf:"bytes,14,opt,name=source,proto3,oneof" json:"source,omitempty"`
+	Hostname       *string                `protobuf:"bytes,15,opt,name=hostname,proto3,oneof" json:"hostname,omitempty"`
+	Ptr            *string                `protobuf:"bytes,16,opt,name=ptr,proto3,oneof" json:"ptr,omitempty"`
+	AddressFamily  *AddressFamily         `protobuf:"bytes,18,opt,name=address_family,json=addressFamily,proto3,oneof" json:"address_family,omitempty"`
+	Aggregate      *Aggregate             `protobuf:"bytes,19,opt,name=aggregate,proto3,oneof" json:"aggregate,omitempty"`
+	Nearest        *Nearest               `protobuf:"bytes,20,opt,name=nearest,proto3,oneof" json:"nearest,omitempty"`
+	Meta           *LookupMeta            `protobuf:"bytes,21,opt,name=meta,proto3,oneof" json:"meta,omitempty"`
+	Error          string                 `protobuf:"bytes,17,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *IpInfo) Reset() {
+	*x = IpInfo{}
+	mi := &file_ipgeo_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IpInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IpInfo) ProtoMessage() {}
+
+func (x *IpInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_ipgeo_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IpInfo.ProtoReflect.Descriptor instead.
+func (*IpInfo) Descriptor() ([]byte, []int) {
+	return file_ipgeo_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *IpInfo) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *IpInfo) GetCountry() string {
+	if x != nil && x.Country != nil {
+		return *x.Country
+	}
+	return ""
+}
+
+func (x *IpInfo) GetIpAddr() string {
+	if x != nil && x.IpAddr != nil {
+		return *x.IpAddr
+	}
+	return ""
+}
+
+func (x *IpInfo) GetIpV6() bool {
+	if x != nil {
+		return x.IpV6
+	}
+	return false
+}
+
+func (x *IpInfo) GetReserved() bool {
+	if x != nil {
+		return x.Reserved
+	}
+	return false
+}
+
+func (x *IpInfo) GetCategory() string {
+	if x != nil && x.Category != nil {
+		return *x.Category
+	}
+	return ""
+}
+
+func (x *IpInfo) GetRir() string {
+	if x != nil && x.Rir != nil {
+		return *x.Rir
+	}
+	return ""
+}
+
+func (x *IpInfo) GetAllocationDate() string {
+	if x != nil && x.AllocationDate != nil {
+		return *x.AllocationDate
+	}
+	return ""
+}
+
+func (x *IpInfo) GetCountryName() string {
+	if x != nil && x.CountryName != nil {
+		return *x.CountryName
+	}
+	return ""
+}
+
+func (x *IpInfo) GetCountries() []string {
+	if x != nil {
+		return x.Countries
+	}
+	return nil
+}
+
+func (x *IpInfo) GetCidr() []string {
+	if x != nil {
+		return x.Cidr
+	}
+	return nil
+}
+
+func (x *IpInfo) GetRangeSize() string {
+	if x != nil && x.RangeSize != nil {
+		return *x.RangeSize
+	}
+	return ""
+}
+
+func (x *IpInfo) GetAccuracy() string {
+	if x != nil && x.Accuracy != nil {
+		return *x.Accuracy
+	}
+	return ""
+}
+
+func (x *IpInfo) GetSource() string {
+	if x != nil && x.Source != nil {
+		return *x.Source
+	}
+	return ""
+}
+
+func (x *IpInfo) GetHostname() string {
+	if x != nil && x.Hostname != nil {
+		return *x.Hostname
+	}
+	return ""
+}
+
+func (x *IpInfo) GetPtr() string {
+	if x != nil && x.Ptr != nil {
+		return *x.Ptr
+	}
+	return ""
+}
+
+func (x *IpInfo) GetAddressFamily() *AddressFamily {
+	if x != nil {
+		return x.AddressFamily
+	}
+	return nil
+}
+
+func (x *IpInfo) GetAggregate() *Aggregate {
+	if x != nil {
+		return x.Aggregate
+	}
+	return nil
+}
+
+func (x *IpInfo) GetNearest() *Nearest {
+	if x != nil {
+		return x.Nearest
+	}
+	return nil
+}
+
+func (x *IpInfo) GetMeta() *LookupMeta {
+	if x != nil {
+		return x.Meta
+	}
+	return nil
+}
+
+func (x *IpInfo) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type AddressFamily struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Presented     string                 `protobuf:"bytes,1,opt,name=presented,proto3" json:"presented,omitempty"`
+	Lookup        string                 `protobuf:"bytes,2,opt,name=lookup,proto3" json:"lookup,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddressFamily) Reset() {
+	*x = AddressFamily{}
+	mi := &file_ipgeo_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddressFamily) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddressFamily) ProtoMessage() {}
+
+func (x *AddressFamily) ProtoReflect() protoreflect.Message {
+	mi := &file_ipgeo_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddressFamily.ProtoReflect.Descriptor instead.
+func (*AddressFamily) Descriptor() ([]byte, []int) {
+	return file_ipgeo_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AddressFamily) GetPresented() string {
+	if x != nil {
+		return x.Presented
+	}
+	return ""
+}
+
+func (x *AddressFamily) GetLookup() string {
+	if x != nil {
+		return x.Lookup
+	}
+	return ""
+}
+
+type Aggregate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Country       *string                `protobuf:"bytes,2,opt,name=country,proto3,oneof" json:"country,omitempty"`
+	Share         float64                `protobuf:"fixed64,3,opt,name=share,proto3" json:"share,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Aggregate) Reset() {
+	*x = Aggregate{}
+	mi := &file_ipgeo_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Aggregate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Aggregate) ProtoMessage() {}
+
+func (x *Aggregate) ProtoReflect() protoreflect.Message {
+	mi := &file_ipgeo_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Aggregate.ProtoReflect.Descriptor instead.
+func (*Aggregate) Descriptor() ([]byte, []int) {
+	return file_ipgeo_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Aggregate) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *Aggregate) GetCountry() string {
+	if x != nil && x.Country != nil {
+		return *x.Country
+	}
+	return ""
+}
+
+func (x *Aggregate) GetShare() float64 {
+	if x != nil {
+		return x.Share
+	}
+	return 0
+}
+
+type Nearest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Below         *NearestRange          `protobuf:"bytes,1,opt,name=below,proto3,oneof" json:"below,omitempty"`
+	Above         *NearestRange          `protobuf:"bytes,2,opt,name=above,proto3,oneof" json:"above,omitempty"`
+	GapStart      string                 `protobuf:"bytes,3,opt,name=gap_start,json=gapStart,proto3" json:"gap_start,omitempty"`
+	GapEnd        string                 `protobuf:"bytes,4,opt,name=gap_end,json=gapEnd,proto3" json:"gap_end,omitempty"`
+	GapSize       string                 `protobuf:"bytes,5,opt,name=gap_size,json=gapSize,proto3" json:"gap_size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Nearest) Reset() {
+	*x = Nearest{}
+	mi := &file_ipgeo_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Nearest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Nearest) ProtoMessage() {}
+
+func (x *Nearest) ProtoReflect() protoreflect.Message {
+	mi := &file_ipgeo_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Nearest.ProtoReflect.Descriptor instead.
+func (*Nearest) Descriptor() ([]byte, []int) {
+	return file_ipgeo_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Nearest) GetBelow() *NearestRange {
+	if x != nil {
+		return x.Below
+	}
+	return nil
+}
+
+func (x *Nearest) GetAbove() *NearestRange {
+	if x != nil {
+		return x.Above
+	}
+	return nil
+}
+
+func (x *Nearest) GetGapStart() string {
+	if x != nil {
+		return x.GapStart
+	}
+	return ""
+}
+
+func (x *Nearest) GetGapEnd() string {
+	if x != nil {
+		return x.GapEnd
+	}
+	return ""
+}
+
+func (x *Nearest) GetGapSize() string {
+	if x != nil {
+		return x.GapSize
+	}
+	return ""
+}
+
+type NearestRange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Start         string                 `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End           string                 `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	Country       string                 `protobuf:"bytes,3,opt,name=country,proto3" json:"country,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NearestRange) Reset() {
+	*x = NearestRange{}
+	mi := &file_ipgeo_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NearestRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NearestRange) ProtoMessage() {}
+
+func (x *NearestRange) ProtoReflect() protoreflect.Message {
+	mi := &file_ipgeo_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NearestRange.ProtoReflect.Descriptor instead.
+func (*NearestRange) Descriptor() ([]byte, []int) {
+	return file_ipgeo_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *NearestRange) GetStart() string {
+	if x != nil {
+		return x.Start
+	}
+	return ""
+}
+
+func (x *NearestRange) GetEnd() string {
+	if x != nil {
+		return x.End
+	}
+	return ""
+}
+
+func (x *NearestRange) GetCountry() string {
+	if x != nil {
+		return x.Country
+	}
+	return ""
+}
+
+type LookupMeta struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Input         string                 `protobuf:"bytes,1,opt,name=input,proto3" json:"input,omitempty"`
+	Normalized    *string                `protobuf:"bytes,2,opt,name=normalized,proto3,oneof" json:"normalized,omitempty"`
+	DurationUs    int64                  `protobuf:"varint,3,opt,name=duration_us,json=durationUs,proto3" json:"duration_us,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupMeta) Reset() {
+	*x = LookupMeta{}
+	mi := &file_ipgeo_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupMeta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupMeta) ProtoMessage() {}
+
+func (x *LookupMeta) ProtoReflect() protoreflect.Message {
+	mi := &file_ipgeo_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupMeta.ProtoReflect.Descriptor instead.
+func (*LookupMeta) Descriptor() ([]byte, []int) {
+	return file_ipgeo_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *LookupMeta) GetInput() string {
+	if x != nil {
+		return x.Input
+	}
+	return ""
+}
+
+func (x *LookupMeta) GetNormalized() string {
+	if x != nil && x.Normalized != nil {
+		return *x.Normalized
+	}
+	return ""
+}
+
+func (x *LookupMeta) GetDurationUs() int64 {
+	if x != nil {
+		return x.DurationUs
+	}
+	return 0
+}
+
+type IpInfoList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*IpInfo              `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IpInfoList) Reset() {
+	*x = IpInfoList{}
+	mi := &file_ipgeo_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IpInfoList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IpInfoList) ProtoMessage() {}
+
+func (x *IpInfoList) ProtoReflect() protoreflect.Message {
+	mi := &file_ipgeo_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IpInfoList.ProtoReflect.Descriptor instead.
+func (*IpInfoList) Descriptor() ([]byte, []int) {
+	return file_ipgeo_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *IpInfoList) GetResults() []*IpInfo {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+var File_ipgeo_proto protoreflect.FileDescriptor
+
+const file_ipgeo_proto_rawDesc = "" +
+	"\n" +
+	"\vipgeo.proto\x12\bipgeo.v1\"\x98\a\n" +
+	"\x06IpInfo\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x1d\n" +
+	"\acountry\x18\x02 \x01(\tH\x00R\acountry\x88\x01\x01\x12\x1c\n" +
+	"\aip_addr\x18\x03 \x01(\tH\x01R\x06ipAddr\x88\x01\x01\x12\x13\n" +
+	"\x05ip_v6\x18\x04 \x01(\bR\x04ipV6\x12\x1a\n" +
+	"\breserved\x18\x05 \x01(\bR\breserved\x12\x1f\n" +
+	"\bcategory\x18\x06 \x01(\tH\x02R\bcategory\x88\x01\x01\x12\x15\n" +
+	"\x03rir\x18\a \x01(\tH\x03R\x03rir\x88\x01\x01\x12,\n" +
+	"\x0fallocation_date\x18\b \x01(\tH\x04R\x0eallocationDate\x88\x01\x01\x12&\n" +
+	"\fcountry_name\x18\t \x01(\tH\x05R\vcountryName\x88\x01\x01\x12\x1c\n" +
+	"\tcountries\x18\n" +
+	" \x03(\tR\tcountries\x12\x12\n" +
+	"\x04cidr\x18\v \x03(\tR\x04cidr\x12\"\n" +
+	"\n" +
+	"range_size\x18\f \x01(\tH\x06R\trangeSize\x88\x01\x01\x12\x1f\n" +
+	"\baccuracy\x18\r \x01(\tH\aR\baccuracy\x88\x01\x01\x12\x1b\n" +
+	"\x06source\x18\x0e \x01(\tH\bR\x06source\x88\x01\x01\x12\x1f\n" +
+	"\bhostname\x18\x0f \x01(\tH\tR\bhostname\x88\x01\x01\x12\x15\n" +
+	"\x03ptr\x18\x10 \x01(\tH\n" +
+	"R\x03ptr\x88\x01\x01\x12C\n" +
+	"\x0eaddress_family\x18\x12 \x01(\v2\x17.ipgeo.v1.AddressFamilyH\vR\raddressFamily\x88\x01\x01\x126\n" +
+	"\taggregate\x18\x13 \x01(\v2\x13.ipgeo.v1.AggregateH\fR\taggregate\x88\x01\x01\x120\n" +
+	"\anearest\x18\x14 \x01(\v2\x11.ipgeo.v1.NearestH\rR\anearest\x88\x01\x01\x12-\n" +
+	"\x04meta\x18\x15 \x01(\v2\x14.ipgeo.v1.LookupMetaH\x0eR\x04meta\x88\x01\x01\x12\x14\n" +
+	"\x05error\x18\x11 \x01(\tR\x05errorB\n" +
+	"\n" +
+	"\b_countryB\n" +
+	"\n" +
+	"\b_ip_addrB\v\n" +
+	"\t_categoryB\x06\n" +
+	"\x04_rirB\x12\n" +
+	"\x10_allocation_dateB\x0f\n" +
+	"\r_country_nameB\r\n" +
+	"\v_range_sizeB\v\n" +
+	"\t_accuracyB\t\n" +
+	"\a_sourceB\v\n" +
+	"\t_hostnameB\x06\n" +
+	"\x04_ptrB\x11\n" +
+	"\x0f_address_familyB\f\n" +
+	"\n" +
+	"_aggregateB\n" +
+	"\n" +
+	"\b_nearestB\a\n" +
+	"\x05_meta\"E\n" +
+	"\rAddressFamily\x12\x1c\n" +
+	"\tpresented\x18\x01 \x01(\tR\tpresented\x12\x16\n" +
+	"\x06lookup\x18\x02 \x01(\tR\x06lookup\"d\n" +
+	"\tAggregate\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12\x1d\n" +
+	"\acountry\x18\x02 \x01(\tH\x00R\acountry\x88\x01\x01\x12\x14\n" +
+	"\x05share\x18\x03 \x01(\x01R\x05shareB\n" +
+	"\n" +
+	"\b_country\"\xd4\x01\n" +
+	"\aNearest\x121\n" +
+	"\x05below\x18\x01 \x01(\v2\x16.ipgeo.v1.NearestRangeH\x00R\x05below\x88\x01\x01\x121\n" +
+	"\x05above\x18\x02 \x01(\v2\x16.ipgeo.v1.NearestRangeH\x01R\x05above\x88\x01\x01\x12\x1b\n" +
+	"\tgap_start\x18\x03 \x01(\tR\bgapStart\x12\x17\n" +
+	"\agap_end\x18\x04 \x01(\tR\x06gapEnd\x12\x19\n" +
+	"\bgap_size\x18\x05 \x01(\tR\agapSizeB\b\n" +
+	"\x06_belowB\b\n" +
+	"\x06_above\"P\n" +
+	"\fNearestRange\x12\x14\n" +
+	"\x05start\x18\x01 \x01(\tR\x05start\x12\x10\n" +
+	"\x03end\x18\x02 \x01(\tR\x03end\x12\x18\n" +
+	"\acountry\x18\x03 \x01(\tR\acountry\"w\n" +
+	"\n" +
+	"LookupMeta\x12\x14\n" +
+	"\x05input\x18\x01 \x01(\tR\x05input\x12#\n" +
+	"\n" +
+	"normalized\x18\x02 \x01(\tH\x00R\n" +
+	"normalized\x88\x01\x01\x12\x1f\n" +
+	"\vduration_us\x18\x03 \x01(\x03R\n" +
+	"durationUsB\r\n" +
+	"\v_normalized\"8\n" +
+	"\n" +
+	"IpInfoList\x12*\n" +
+	"\aresults\x18\x01 \x03(\v2\x10.ipgeo.v1.IpInfoR\aresultsB\x14Z\x12Ip-geo-API/ipgeopbb\x06proto3"
+
+var (
+	file_ipgeo_proto_rawDescOnce sync.Once
+	file_ipgeo_proto_rawDescData []byte
+)
+
+func file_ipgeo_proto_rawDescGZIP() []byte {
+	file_ipgeo_proto_rawDescOnce.Do(func() {
+		file_ipgeo_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_ipgeo_proto_rawDesc), len(file_ipgeo_proto_rawDesc)))
+	})
+	return file_ipgeo_proto_rawDescData
+}
+
+var file_ipgeo_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_ipgeo_proto_goTypes = []any{
+	(*IpInfo)(nil),        // 0: ipgeo.v1.IpInfo
+	(*AddressFamily)(nil), // 1: ipgeo.v1.AddressFamily
+	(*Aggregate)(nil),     // 2: ipgeo.v1.Aggregate
+	(*Nearest)(nil),       // 3: ipgeo.v1.Nearest
+	(*NearestRange)(nil),  // 4: ipgeo.v1.NearestRange
+	(*LookupMeta)(nil),    // 5: ipgeo.v1.LookupMeta
+	(*IpInfoList)(nil),    // 6: ipgeo.v1.IpInfoList
+}
+var file_ipgeo_proto_depIdxs = []int32{
+	1, // 0: ipgeo.v1.IpInfo.address_family:type_name -> ipgeo.v1.AddressFamily
+	2, // 1: ipgeo.v1.IpInfo.aggregate:type_name -> ipgeo.v1.Aggregate
+	3, // 2: ipgeo.v1.IpInfo.nearest:type_name -> ipgeo.v1.Nearest
+	5, // 3: ipgeo.v1.IpInfo.meta:type_name -> ipgeo.v1.LookupMeta
+	4, // 4: ipgeo.v1.Nearest.below:type_name -> ipgeo.v1.NearestRange
+	4, // 5: ipgeo.v1.Nearest.above:type_name -> ipgeo.v1.NearestRange
+	0, // 6: ipgeo.v1.IpInfoList.results:type_name -> ipgeo.v1.IpInfo
+	7, // [7:7] is the sub-list for method output_type
+	7, // [7:7] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_ipgeo_proto_init() }
+func file_ipgeo_proto_init() {
+	if File_ipgeo_proto != nil {
+		return
+	}
+	file_ipgeo_proto_msgTypes[0].OneofWrappers = []any{}
+	file_ipgeo_proto_msgTypes[2].OneofWrappers = []any{}
+	file_ipgeo_proto_msgTypes[3].OneofWrappers = []any{}
+	file_ipgeo_proto_msgTypes[5].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_ipgeo_proto_rawDesc), len(file_ipgeo_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_ipgeo_proto_goTypes,
+		DependencyIndexes: file_ipgeo_proto_depIdxs,
+		MessageInfos:      file_ipgeo_proto_msgTypes,
+	}.Build()
+	File_ipgeo_proto = out.File
+	file_ipgeo_proto_goTypes = nil
+	file_ipgeo_proto_depIdxs = nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// setupLogger installs the default slog logger with the configured level
+// and format
+func setupLogger(cfg *Config) {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+
+	var handler slog.Handler
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
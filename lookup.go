@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/big"
+	"net"
+	"sort"
+	"sync"
+)
+
+// ipToNum converts addr to the decimal numbering used by the -num CSVs.
+// IPv4 and IPv4-mapped addresses take the IPv4 branch, so the IPv6 branch
+// only ever sees genuine IPv6 and always numbers its 16-byte form. A
+// malformed addr numbers as 0, which matches no range.
+func ipToNum(addr net.IP) *big.Int {
+	if v4 := addr.To4(); v4 != nil {
+		return new(big.Int).SetUint64(uint64(binary.BigEndian.Uint32(v4)))
+	}
+	return new(big.Int).SetBytes(addr.To16())
+}
+
+// ipv6NumPool recycles the numbers of IPv6 lookups, whose 16-byte values
+// would otherwise allocate on every request
+var ipv6NumPool = sync.Pool{New: func() any { return new(big.Int) }}
+
+// findRange returns the range in the sorted arr containing ipNum, or nil.
+// When ranges touch or overlap, the one with the greatest start <= ipNum
+// wins, so an IP equal to one range's end and the next one's start belongs
+// to the later range. Among ranges sharing that start, the widest wins.
+func findRange(arr []IpAddressRange, ipNum *big.Int) *IpAddressRange {
+	if ipNum.Sign() == 0 {
+		return nil
+	}
+	// idx is the first range starting after ipNum. It is 0 when arr is
+	// empty or ipNum is below every range, so there is no candidate, and
+	// len(arr) when ipNum is above every start, so the last range is the
+	// candidate and matches up to and including its end.
+	idx := sort.Search(len(arr), func(i int) bool {
+		return arr[i].start.Cmp(ipNum) > 0
+	})
+	if idx == 0 {
+		return nil
+	}
+	if candidate := &arr[idx-1]; candidate.end.Cmp(ipNum) >= 0 {
+		return candidate
+	}
+	return nil
+}
+
+// rangeSize returns the number of addresses in r, end - start + 1
+func rangeSize(r *IpAddressRange) *big.Int {
+	size := new(big.Int).Sub(r.end, r.start)
+	return size.Add(size, big.NewInt(1))
+}
+
+// runningMaxEnd returns, for each i, the greatest end among arr[:i+1], which
+// bounds how far back findAllRanges has to look
+func runningMaxEnd(arr []IpAddressRange) []*big.Int {
+	maxEnd := make([]*big.Int, len(arr))
+	for i := range arr {
+		maxEnd[i] = arr[i].end
+		if i > 0 && maxEnd[i-1].Cmp(arr[i].end) > 0 {
+			maxEnd[i] = maxEnd[i-1]
+		}
+	}
+	return maxEnd
+}
+
+// findAllRanges returns every range in the sorted arr containing ipNum, by
+// descending start, so findRange's answer, if any, comes first. maxEnd is
+// arr's runningMaxEnd.
+func findAllRanges(arr []IpAddressRange, maxEnd []*big.Int, ipNum *big.Int) []*IpAddressRange {
+	if ipNum.Sign() == 0 {
+		return nil
+	}
+	idx := sort.Search(len(arr), func(i int) bool {
+		return arr[i].start.Cmp(ipNum) > 0
+	})
+	var matches []*IpAddressRange
+	for j := idx - 1; j >= 0 && maxEnd[j].Cmp(ipNum) >= 0; j-- {
+		if arr[j].end.Cmp(ipNum) >= 0 {
+			matches = append(matches, &arr[j])
+		}
+	}
+	return matches
+}
+
+// lookup resolves rawIpAddr against arr. addr is the parsed address when
+// the input was a valid IP, whether or not it matched a range.
+func lookup(arr []IpAddressRange, rawIpAddr string) (resp ApiResponse, addr net.IP) {
+	ipAddr := parseIpAddress(rawIpAddr)
+	if ipAddr == nil || ipAddr.IpAddr == nil {
+		return ApiResponse{Ok: false, Error: codeInvalidIP}, nil
+	}
+	addr = net.ParseIP(*ipAddr.IpAddr)
+	if addr == nil {
+		return ApiResponse{Ok: false, Error: codeInvalidIP}, nil
+	}
+
+	if category := reservedCategory(addr); category != "" {
+		return ApiResponse{Ok: false, IpAddress: *ipAddr, Reserved: true, Category: &category, Error: codeReserved}, addr
+	}
+
+	var ipNum *big.Int
+	if addr.To4() == nil {
+		// Nothing keeps ipNum past the lookup, so it can go back to the
+		// pool on return. SetBytes overwrites whatever it held before.
+		ipNum = ipv6NumPool.Get().(*big.Int).SetBytes(addr.To16())
+		defer ipv6NumPool.Put(ipNum)
+	} else {
+		ipNum = ipToNum(addr)
+	}
+	if addr.To4() == nil && ipNum.Cmp(maxIpv4Num) <= 0 {
+		// IPv6 numbers this small would land in the IPv4 ranges, which
+		// share the number space
+		return ApiResponse{Ok: false, Error: codeNotFound}, addr
+	}
+	if match := findRange(arr, ipNum); match != nil {
+		return ApiResponse{Ok: true, Country: &match.country, IpAddress: *ipAddr}, addr
+	}
+	return ApiResponse{Ok: false, Error: codeNotFound}, addr
+}
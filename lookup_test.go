@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testRanges builds sorted ranges from "start-end:country" specs
+func testRanges(specs ...string) []IpAddressRange {
+	arr := make([]IpAddressRange, len(specs))
+	for i, spec := range specs {
+		bounds, country, _ := strings.Cut(spec, ":")
+		start, end, _ := strings.Cut(bounds, "-")
+		arr[i] = IpAddressRange{start: bigNum(start), end: bigNum(end), country: country}
+	}
+	return arr
+}
+
+func bigNum(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("bad test number " + s)
+	}
+	return n
+}
+
+func TestIpToNum(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.IP
+		want string
+	}{
+		{name: "ipv4", addr: net.ParseIP("1.2.3.4"), want: "16909060"},
+		{name: "ipv4 in 4 bytes", addr: net.IP{1, 2, 3, 4}, want: "16909060"},
+		// The mapped form is 16 bytes too, but must number as the IPv4
+		// address it embeds, not as ::ffff:0:0 plus it (281470698652420)
+		{name: "ipv4-mapped", addr: net.ParseIP("::ffff:1.2.3.4"), want: "16909060"},
+		{name: "loopback", addr: net.ParseIP("::1"), want: "1"},
+		{name: "documentation", addr: net.ParseIP("2001:db8::1"), want: "42540766411282592856903984951653826561"},
+		{name: "all ones", addr: net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"), want: "340282366920938463463374607431768211455"},
+		{name: "malformed", addr: net.IP{1, 2, 3}, want: "0"},
+	}
+	for _, tt := range tests {
+		if got := ipToNum(tt.addr).String(); got != tt.want {
+			t.Errorf("ipToNum(%s) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFindRange(t *testing.T) {
+	arr := testRanges("10-19:AA", "20-29:BB", "40-49:CC")
+	tests := []struct {
+		name string
+		arr  []IpAddressRange
+		ip   string
+		want string // country of the match, "" for none
+	}{
+		{name: "empty", arr: nil, ip: "15"},
+		{name: "zero", arr: testRanges("0-9:AA"), ip: "0"},
+		{name: "below first", arr: arr, ip: "9"},
+		{name: "first start", arr: arr, ip: "10", want: "AA"},
+		{name: "inside", arr: arr, ip: "15", want: "AA"},
+		{name: "first end", arr: arr, ip: "19", want: "AA"},
+		{name: "touching start", arr: arr, ip: "20", want: "BB"},
+		{name: "gap", arr: arr, ip: "30"},
+		{name: "last start", arr: arr, ip: "40", want: "CC"},
+		{name: "last end", arr: arr, ip: "49", want: "CC"},
+		{name: "above last", arr: arr, ip: "50"},
+		{name: "single address", arr: testRanges("7-7:DD"), ip: "7", want: "DD"},
+		// 20 ends one range and starts the next, and the later start wins
+		{name: "overlapping boundary", arr: testRanges("10-20:AA", "20-29:BB"), ip: "20", want: "BB"},
+		{name: "before overlapping boundary", arr: testRanges("10-20:AA", "20-29:BB"), ip: "19", want: "AA"},
+		// Ranges sharing a start sort narrowest first, so the widest wins
+		{name: "shared start", arr: testRanges("10-15:AA", "10-19:BB"), ip: "12", want: "BB"},
+		{name: "ipv6", arr: testRanges("42540766411282592856903984951653826560-42540766490510755371168322545197776895:US"), ip: "42540766411282592856903984951653826561", want: "US"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if match := findRange(tt.arr, bigNum(tt.ip)); match != nil {
+				got = match.country
+			}
+			if got != tt.want {
+				t.Errorf("findRange(%s) = %q, want %q", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindAllRanges(t *testing.T) {
+	// 10-99 spans the others, so only the running max end finds it from 60
+	arr := testRanges("10-99:AA", "20-29:BB", "25-35:CC", "60-69:DD")
+	maxEnd := runningMaxEnd(arr)
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "5", want: ""},
+		{ip: "15", want: "AA"},
+		{ip: "27", want: "CC,BB,AA"},
+		{ip: "32", want: "CC,AA"},
+		{ip: "65", want: "DD,AA"},
+		{ip: "99", want: "AA"},
+		{ip: "100", want: ""},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, match := range findAllRanges(arr, maxEnd, bigNum(tt.ip)) {
+			got = append(got, match.country)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("findAllRanges(%s) = %v, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestRangeSize(t *testing.T) {
+	for _, tt := range []struct{ spec, want string }{
+		{"7-7:AA", "1"},
+		{"0-255:AA", "256"},
+		{"0-340282366920938463463374607431768211455:AA", "340282366920938463463374607431768211456"},
+	} {
+		if got := rangeSize(&testRanges(tt.spec)[0]).String(); got != tt.want {
+			t.Errorf("rangeSize(%s) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+// syntheticRanges returns n4 consecutive IPv4 /24s and n6 IPv6 /48s from
+// 2400::, cycling through a few countries, sorted as loaded data is
+func syntheticRanges(n4, n6 int) []IpAddressRange {
+	countries := []string{"US", "DE", "JP", "BR", "AU"}
+	arr := make([]IpAddressRange, 0, n4+n6)
+	v4 := big.NewInt(1 << 24)
+	size := big.NewInt(1 << 8)
+	for i := range n4 {
+		start := new(big.Int).Add(v4, new(big.Int).Mul(size, big.NewInt(int64(i))))
+		end := new(big.Int).Add(start, size)
+		arr = append(arr, IpAddressRange{start: start, end: end.Sub(end, one), country: countries[i%len(countries)]})
+	}
+	v6 := new(big.Int).Lsh(big.NewInt(0x2400), 112)
+	size = new(big.Int).Lsh(one, 80)
+	for i := range n6 {
+		start := new(big.Int).Add(v6, new(big.Int).Mul(size, big.NewInt(int64(i))))
+		end := new(big.Int).Add(start, size)
+		arr = append(arr, IpAddressRange{start: start, end: end.Sub(end, one), country: countries[i%len(countries)]})
+	}
+	return arr
+}
+
+// BenchmarkLookup looks up addresses spread over a dataset the size of the
+// sapics files, about 500k IPv4 and 200k IPv6 ranges
+func BenchmarkLookup(b *testing.B) {
+	arr := syntheticRanges(500_000, 200_000)
+	for _, bench := range []struct {
+		name  string
+		addrs []string
+		match bool
+	}{
+		{"ipv4", []string{"1.0.0.1", "3.120.7.9", "8.8.8.8", "5.255.255.254"}, true},
+		{"ipv6", []string{"2400::1", "2400:0:1234::1", "2400:2:ffff::1", "2400:1:2::"}, true},
+		{"miss", []string{"223.255.255.1", "2a00::1"}, false},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for _, raw := range bench.addrs {
+				if resp, _ := lookup(arr, raw); resp.Ok != bench.match {
+					b.Fatalf("lookup(%s) ok = %v, want %v", raw, resp.Ok, bench.match)
+				}
+			}
+			b.ReportAllocs()
+			for i := 0; b.Loop(); i++ {
+				lookup(arr, bench.addrs[i%len(bench.addrs)])
+			}
+		})
+	}
+}
+
+// TestLookupIPv6Concurrent checks that lookups sharing ipv6NumPool from
+// many goroutines each get the answer for their own address
+func TestLookupIPv6Concurrent(t *testing.T) {
+	arr := syntheticRanges(0, 1000)
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 2000 {
+				n := (g*2000 + i) % 1000
+				raw := fmt.Sprintf("2400:0:%x::%x", n, i+1)
+				resp, _ := lookup(arr, raw)
+				if !resp.Ok || *resp.Country != arr[n].country || *resp.IpAddr != raw {
+					t.Errorf("lookup(%s) = %+v, want %s", raw, resp, arr[n].country)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkIpv6Num compares taking an IPv6 lookup's number from
+// ipv6NumPool with allocating it, as the lookup did before the pool
+func BenchmarkIpv6Num(b *testing.B) {
+	addr := net.ParseIP("2400:cb00:2048:1::6814:155")
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				n := ipv6NumPool.Get().(*big.Int).SetBytes(addr.To16())
+				ipv6NumPool.Put(n)
+			}
+		})
+	})
+	b.Run("alloc", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				ipToNum(addr)
+			}
+		})
+	})
+}
+
+// BenchmarkLookupIPv6Parallel is BenchmarkLookup's IPv6 case from every
+// CPU at once, the load the pool is for
+func BenchmarkLookupIPv6Parallel(b *testing.B) {
+	arr := syntheticRanges(0, 200_000)
+	addrs := []string{"2400::1", "2400:0:1234::1", "2400:2:ffff::1", "2400:1:2::"}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			lookup(arr, addrs[i%len(addrs)])
+		}
+	})
+}
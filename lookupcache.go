@@ -0,0 +1,159 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// lookupLocalCacheSize bounds the in-memory cache used while Redis is
+	// down, which starts over once full
+	lookupLocalCacheSize = 10000
+	// redisCooldown is how long a failing Redis is left alone before it is
+	// tried again
+	redisCooldown = 30 * time.Second
+)
+
+// lookupCache caches the answers of the local data, keyed by address and
+// dataset version, so instances sharing a Redis look up a hot address
+// once per dataset. While Redis fails it falls back to an in-memory cache
+// for the current dataset. Only valid addresses are cached, and the
+// fallback API's answers aren't, since it caches them itself.
+type lookupCache struct {
+	redis *redisClient
+	ttl   time.Duration
+
+	mu sync.Mutex
+	// local holds the answers for localData while Redis is down
+	local     map[string]string
+	localData *dataset
+	downUntil time.Time
+}
+
+func newLookupCache(cfg *Config) (*lookupCache, error) {
+	redis, err := newRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	l := &lookupCache{redis: redis, ttl: cfg.RedisTTL}
+	if err := redis.ping(); err != nil {
+		l.redisFailed(err)
+	}
+	return l, nil
+}
+
+// cachedLookup is lookup, answered from the cache when the address was
+// looked up in the same dataset before
+func (a *app) cachedLookup(data *dataset, rawIpAddr string) (ApiResponse, net.IP) {
+	if a.lookups == nil || data.version == "" {
+		return lookup(data.arr, rawIpAddr)
+	}
+	if _, err := netip.ParseAddr(rawIpAddr); err != nil {
+		return lookup(data.arr, rawIpAddr)
+	}
+	key := "ipgeo:lookup:" + data.version + ":" + rawIpAddr
+	if value, ok := a.lookups.get(data, key); ok {
+		if resp, ok := decodeCachedLookup(rawIpAddr, value); ok {
+			return resp, net.ParseIP(rawIpAddr)
+		}
+	}
+	resp, addr := lookup(data.arr, rawIpAddr)
+	if value, ok := encodeCachedLookup(resp); ok && addr != nil {
+		a.lookups.set(data, key, value)
+	}
+	return resp, addr
+}
+
+func (l *lookupCache) get(data *dataset, key string) (string, bool) {
+	if l.redisUp() {
+		value, ok, err := l.redis.get(key)
+		if err == nil {
+			return value, ok
+		}
+		l.redisFailed(err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.localData != data {
+		return "", false
+	}
+	value, ok := l.local[key]
+	return value, ok
+}
+
+func (l *lookupCache) set(data *dataset, key, value string) {
+	if l.redisUp() {
+		err := l.redis.set(key, value, l.ttl)
+		if err == nil {
+			return
+		}
+		l.redisFailed(err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.localData != data || len(l.local) >= lookupLocalCacheSize {
+		l.local, l.localData = map[string]string{}, data
+	}
+	l.local[key] = value
+}
+
+// redisUp reports whether Redis should be tried, logging when it is tried
+// again after a failure
+func (l *lookupCache) redisUp() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.downUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(l.downUntil) {
+		return false
+	}
+	l.downUntil = time.Time{}
+	l.local, l.localData = nil, nil
+	slog.Info("retrying Redis for the lookup cache")
+	return true
+}
+
+func (l *lookupCache) redisFailed(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.downUntil.IsZero() {
+		slog.Warn("Redis unavailable, caching lookups in memory", "cooldown", redisCooldown, "err", err)
+	}
+	l.downUntil = time.Now().Add(redisCooldown)
+}
+
+// encodeCachedLookup encodes the answer of lookup for a valid address:
+// "c" and the country for a match, "r" and the category for a reserved
+// address and "n" for a miss
+func encodeCachedLookup(resp ApiResponse) (string, bool) {
+	switch {
+	case resp.Ok:
+		return "c" + *resp.Country, true
+	case resp.Reserved:
+		return "r" + *resp.Category, true
+	case resp.Error == codeNotFound:
+		return "n", true
+	}
+	return "", false
+}
+
+func decodeCachedLookup(rawIpAddr, value string) (ApiResponse, bool) {
+	ipAddr := parseIpAddress(rawIpAddr)
+	if ipAddr == nil || value == "" {
+		return ApiResponse{}, false
+	}
+	rest := value[1:]
+	switch value[0] {
+	case 'c':
+		return ApiResponse{Ok: true, Country: &rest, IpAddress: *ipAddr}, true
+	case 'r':
+		return ApiResponse{Ok: false, IpAddress: *ipAddr, Reserved: true, Category: &rest, Error: codeReserved}, true
+	case 'n':
+		return ApiResponse{Ok: false, Error: codeNotFound}, true
+	}
+	return ApiResponse{}, false
+}
@@ -1,21 +1,30 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha1"
-	"encoding/binary"
 	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math/big"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -23,7 +32,6 @@ import (
 )
 
 const (
-	dataDir   = "/app/data"
 	repoOwner = "sapics"
 	repoName  = "ip-location-db"
 	branch    = "main"
@@ -32,130 +40,572 @@ const (
 type fileInfo struct {
 	RemotePath string
 	LocalName  string
+	IpV6       bool
 }
 
-var files = []fileInfo{
-	{"geo-whois-asn-country/geo-whois-asn-country-ipv4-num.csv", "geo-whois-asn-country-ipv4-num.csv"},
-	{"geo-asn-country/geo-asn-country-ipv6-num.csv", "geo-asn-country-ipv6-num.csv"},
+// Source names the dataset a file belongs to, e.g. "geo-asn-country"
+func (fi *fileInfo) Source() string {
+	return path.Dir(fi.RemotePath)
+}
+
+// defaultFiles are the data files loaded unless the config file lists its
+// own
+var defaultFiles = []fileInfo{
+	{"geo-whois-asn-country/geo-whois-asn-country-ipv4-num.csv", "geo-whois-asn-country-ipv4-num.csv", false},
+	{"geo-asn-country/geo-asn-country-ipv6-num.csv", "geo-asn-country-ipv6-num.csv", true},
 }
 
 type githubContent struct {
-	SHA         string `json:"sha"`
-	DownloadURL string `json:"download_url"`
+	SHA  string `json:"sha"`
+	Size int64  `json:"size"`
+}
+
+// updateResult lists the files an update downloaded and, in dry-run mode,
+// the files it would have downloaded, along with the outcome for each file
+type updateResult struct {
+	Updated []string
+	Pending []string
+	Files   []fileUpdateStatus
 }
 
-// updateCsvFiles ensures CSV files exist in dataDir and updates them if needed
-func updateCsvFiles() error {
-	autoUpdate := strings.ToLower(strings.TrimSpace(os.Getenv("AUTO_UPDATE"))) == "true"
+// fileUpdateStatus is the outcome of updating one file
+type fileUpdateStatus struct {
+	File   string `json:"file"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
 
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return fmt.Errorf("creating data directory: %w", err)
+// updateCsvFiles ensures CSV files exist in the data directory and updates
+// them if needed. Files are checked concurrently and only those whose SHA
+// changed are downloaded.
+func updateCsvFiles(cfg *Config) (updateResult, error) {
+	var res updateResult
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return res, fmt.Errorf("creating data directory: %w", err)
 	}
 
-	for _, fi := range files {
-		localPath := filepath.Join(dataDir, fi.LocalName)
-		// Check local existence
-		_, err := os.Stat(localPath)
-		exists := err == nil
-
-		// If file missing or auto-update enabled, check remote
-		if !exists || autoUpdate {
-			// Fetch remote metadata
-			apiURL := fmt.Sprintf(
-				"https://api.github.com/repos/%s/%s/contents/%s?ref=%s",
-				repoOwner, repoName, fi.RemotePath, branch,
-			)
-			resp, err := http.Get(apiURL)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, fi := range cfg.Files {
+		if !cfg.familyEnabled(fi.IpV6) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outcome, err := updateCsvFile(cfg, fi)
+
+			mu.Lock()
+			defer mu.Unlock()
+			st := fileUpdateStatus{File: fi.LocalName, Status: outcome.String()}
 			if err != nil {
-				return fmt.Errorf("fetching remote metadata: %w", err)
+				st.Error = err.Error()
 			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				return fmt.Errorf("bad status from GitHub API: %s", resp.Status)
+			res.Files = append(res.Files, st)
+			switch {
+			case outcome == fileRejected:
+				// The previous file is kept, so this isn't fatal
+			case err != nil:
+				errs = append(errs, fmt.Errorf("%s: %w", fi.LocalName, err))
+			case outcome == fileDownloaded:
+				res.Updated = append(res.Updated, fi.LocalName)
+			case outcome == fileChangePending:
+				res.Pending = append(res.Pending, fi.LocalName)
 			}
+		}()
+	}
+	wg.Wait()
 
-			var meta githubContent
-			if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
-				return fmt.Errorf("decoding GitHub response: %w", err)
-			}
+	sort.Strings(res.Updated)
+	sort.Strings(res.Pending)
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].File < res.Files[j].File })
+	return res, errors.Join(errs...)
+}
 
-			download := true
-			if exists {
-				data, err := os.ReadFile(localPath)
-				if err == nil {
-					header := fmt.Sprintf("blob %d\x00", len(data))
-					h := sha1.Sum(append([]byte(header), data...))
-					localSha := hex.EncodeToString(h[:])
-					download = localSha != meta.SHA
-				}
-			}
+type fileUpdate int
 
-			if download {
-				// Download new file
-				dlResp, err := http.Get(meta.DownloadURL)
-				if err != nil {
-					return fmt.Errorf("downloading file: %w", err)
-				}
-				defer dlResp.Body.Close()
+const (
+	fileUnchanged fileUpdate = iota
+	fileDownloaded
+	// fileChangePending means the remote file changed but dry-run mode
+	// skipped the download
+	fileChangePending
+	// fileRejected means a changed file's download failed validation and
+	// the previous file was kept
+	fileRejected
+	fileFailed
+)
 
-				out, err := os.Create(localPath)
-				if err != nil {
-					return fmt.Errorf("creating local file: %w", err)
-				}
-				defer out.Close()
+func (u fileUpdate) String() string {
+	switch u {
+	case fileDownloaded:
+		return "downloaded"
+	case fileChangePending:
+		return "pending"
+	case fileRejected:
+		return "rejected"
+	case fileFailed:
+		return "failed"
+	default:
+		return "unchanged"
+	}
+}
 
-				if _, err := io.Copy(out, dlResp.Body); err != nil {
-					return fmt.Errorf("writing file: %w", err)
-				}
-				log.Printf("updated %s", fi.LocalName)
+// updateCsvFile downloads fi if it's missing locally, or if auto-update is
+// enabled and its SHA differs from the remote one. In dry-run mode a changed
+// file that exists locally is only reported, never overwritten.
+func updateCsvFile(cfg *Config, fi fileInfo) (fileUpdate, error) {
+	localPath := filepath.Join(cfg.DataDir, fi.LocalName)
+	// Check local existence
+	_, err := os.Stat(localPath)
+	exists := err == nil
+
+	// Only check remote if file missing or auto-update (or its dry run)
+	// enabled
+	if exists && !cfg.AutoUpdate && !cfg.AutoUpdateDryRun {
+		return fileUnchanged, nil
+	}
+
+	// Fetch remote metadata
+	apiURL := fmt.Sprintf(
+		"%s/repos/%s/%s/contents/%s?ref=%s",
+		cfg.GithubAPIBase, repoOwner, repoName, fi.RemotePath, branch,
+	)
+	resp, err := cfg.outbound.Get(apiURL)
+	if err != nil {
+		return fileFailed, fmt.Errorf("fetching remote metadata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fileFailed, fmt.Errorf("bad status from GitHub API: %s", resp.Status)
+	}
+
+	var meta githubContent
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return fileFailed, fmt.Errorf("decoding GitHub response: %w", err)
+	}
+
+	if exists {
+		if localSha, err := gitBlobSha(localPath); err == nil && localSha == meta.SHA {
+			// Bump the mtime so the file's age reflects when it was last
+			// confirmed current, not when it was first downloaded
+			now := time.Now()
+			if err := os.Chtimes(localPath, now, now); err != nil {
+				slog.Warn("couldn't mark data file as confirmed current", "file", fi.LocalName, "err", err)
 			}
+			return fileUnchanged, nil
 		}
 	}
-	return nil
+
+	if exists && cfg.AutoUpdateDryRun {
+		slog.Info("dry run: would download changed data file", "file", fi.LocalName, "sha", meta.SHA)
+		return fileChangePending, nil
+	}
+
+	// Download new file
+	rawURL := fmt.Sprintf("%s/%s/%s/%s/%s", cfg.GithubRawBase, repoOwner, repoName, branch, fi.RemotePath)
+	if err := downloadCsvFile(cfg.outbound, rawURL, meta, localPath, cfg.csvFormat(&fi)); err != nil {
+		if exists {
+			slog.Warn("rejected data file download, keeping previous file", "file", fi.LocalName, "reason", err)
+			return fileRejected, err
+		}
+		return fileFailed, err
+	}
+	slog.Info("updated data file", "file", fi.LocalName)
+	return fileDownloaded, nil
+}
+
+// gitBlobSha hashes the file at path the way git does, which is what the
+// GitHub contents API reports as its SHA. The file is streamed through the
+// hash rather than read into memory.
+func gitBlobSha(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", info.Size())
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 type IpAddressRange struct {
 	start   *big.Int
 	end     *big.Int
 	country string
+	source  *fileInfo
 }
 
-// loadCsv reads local CSVs and returns sorted ranges
-func loadCsv() []IpAddressRange {
-	arr := []IpAddressRange{}
+// fileStatus records how loading one data file went
+type fileStatus struct {
+	File     string `json:"file"`
+	Source   string `json:"source"`
+	Required bool   `json:"required"`
+	Loaded   bool   `json:"loaded"`
+	Rows     int    `json:"rows"`
+	Skipped  int    `json:"skipped"`
+	Error    string `json:"error,omitempty"`
+	// Disabled is set when the file's address family or source is turned
+	// off, in which case it isn't loaded at all
+	Disabled bool   `json:"disabled,omitempty"`
+	SHA      string `json:"sha,omitempty"`
+	// Modified is when the file was last downloaded or confirmed current
+	Modified time.Time `json:"modified,omitzero"`
+}
 
-	for _, fi := range files {
-		path := filepath.Join(dataDir, fi.LocalName)
-		f, err := os.Open(path)
-		if err != nil {
+// loadCsv reads local CSVs from cfg.DataDir and returns sorted ranges along
+// with the load status of each file. Files of a disabled family or source
+// are skipped. A file that can't be read or yields no ranges is reported as
+// not loaded; that is an error only if its source is required.
+func loadCsv(cfg *Config) ([]IpAddressRange, []fileStatus, error) {
+	// Size the slice up front so growing it doesn't leave discarded copies
+	// behind while the previous dataset is still being served
+	capacity := 0
+	for i := range cfg.Files {
+		if cfg.fileEnabled(&cfg.Files[i]) {
+			capacity += countLines(filepath.Join(cfg.DataDir, cfg.Files[i].LocalName))
+		}
+	}
+	arr := make([]IpAddressRange, 0, capacity)
+	statuses := make([]fileStatus, len(cfg.Files))
+	var errs []error
+	parseStart := time.Now()
+
+	for i := range cfg.Files {
+		fi := &cfg.Files[i]
+		st := &statuses[i]
+		st.File, st.Source, st.Required = fi.LocalName, fi.Source(), cfg.RequiredSources[fi.Source()]
+		if !cfg.fileEnabled(fi) {
+			st.Disabled = true
 			continue
 		}
-		defer f.Close()
 
-		r := csv.NewReader(f)
-		for {
-			rec, err := r.Read()
-			if err != nil {
-				break
+		format := cfg.csvFormat(fi)
+		counts, err := readRangeCsv(filepath.Join(cfg.DataDir, fi.LocalName), format, 3, func(start, end *big.Int, rec []string) {
+			arr = append(arr, IpAddressRange{start, end, rec[format.col], fi})
+			st.Rows++
+		})
+		st.Skipped = counts.skipped
+		if err == nil && st.Rows == 0 {
+			err = errors.New("no valid rows")
+		}
+
+		if counts.skipped > 0 {
+			slog.Warn("skipped malformed rows", "file", fi.LocalName, "rows", counts.skipped)
+		}
+		if st.Rows > 0 {
+			slog.Info("read range bounds", "file", fi.LocalName, "representation", counts.representation(st.Rows), "address_rows", counts.fromAddr, "range_format", counts.rangeFormat())
+		}
+		if err != nil {
+			st.Error = err.Error()
+			slog.Warn("data file failed to load, its ranges will be missing", "file", fi.LocalName, "required", st.Required, "err", err)
+			if st.Required {
+				errs = append(errs, fmt.Errorf("%s: %w", fi.LocalName, err))
 			}
-			start, ok := new(big.Int).SetString(rec[0], 10)
-			if !ok {
+			continue
+		}
+		st.Loaded = true
+		if sha, err := gitBlobSha(filepath.Join(cfg.DataDir, fi.LocalName)); err == nil {
+			st.SHA = sha
+		}
+		if info, err := os.Stat(filepath.Join(cfg.DataDir, fi.LocalName)); err == nil {
+			st.Modified = info.ModTime()
+		}
+	}
+
+	logPhase("parse", parseStart)
+
+	sortStart := time.Now()
+	sortRanges(arr)
+	logPhase("sort", sortStart)
+	return arr, statuses, errors.Join(errs...)
+}
+
+// countLines returns the number of lines in the file at path, or 0 if it
+// can't be read
+func countLines(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	n := 0
+	buf := make([]byte, 64*1024)
+	for {
+		read, err := f.Read(buf)
+		n += bytes.Count(buf[:read], []byte{'\n'})
+		if err != nil {
+			return n
+		}
+	}
+}
+
+// logPhase logs how long a startup phase that began at start took
+func logPhase(phase string, start time.Time) {
+	slog.Info("data load phase", "phase", phase, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// sortRanges orders arr by start, then by end, keeping file order for exact
+// duplicates so lookups are deterministic across restarts
+func sortRanges(arr []IpAddressRange) {
+	sort.SliceStable(arr, func(i, j int) bool {
+		return compareBounds(&arr[i], &arr[j]) < 0
+	})
+}
+
+// dedupeRanges drops ranges of the sorted arr that repeat an earlier one's
+// start, end and country, as when merged sources list the same block, and
+// returns the shortened arr. The first copy, from the earliest file, is kept.
+func dedupeRanges(arr []IpAddressRange) []IpAddressRange {
+	if len(arr) == 0 {
+		return arr
+	}
+	kept := 1
+	for i := 1; i < len(arr); i++ {
+		if !repeatsKept(arr[:kept], &arr[i]) {
+			arr[kept] = arr[i]
+			kept++
+		}
+	}
+	if dropped := len(arr) - kept; dropped > 0 {
+		slog.Info("dropped duplicate ranges", "ranges", dropped)
+	}
+	clear(arr[kept:])
+	return arr[:kept]
+}
+
+// repeatsKept reports whether r repeats one of the trailing ranges of kept
+// with the same start and end
+func repeatsKept(kept []IpAddressRange, r *IpAddressRange) bool {
+	for j := len(kept) - 1; j >= 0 && kept[j].start.Cmp(r.start) == 0 && kept[j].end.Cmp(r.end) == 0; j-- {
+		if kept[j].country == r.country {
+			return true
+		}
+	}
+	return false
+}
+
+// csvFormat describes how a data file encodes its rows: the base of the
+// start/end numbers (see parseRangeNum), the column of each field, the
+// columns holding the bounds as addresses too, -1 if none, the field
+// delimiter and the comment character, if any. rangeFormat is whether the
+// end column holds the end ("start-end") or a prefix length of up to bits
+// ("start-prefix"), or "auto" to tell from the first row.
+type csvFormat struct {
+	base                     int
+	startCol, endCol, col    int
+	addrStartCol, addrEndCol int
+	comma, comment           rune
+	rangeFormat              string
+	bits                     int
+}
+
+// defaultCsvFormat is the layout of the sapics -num CSVs:
+// start_num,end_num,country in decimal, comma-separated without comments
+var defaultCsvFormat = csvFormat{base: 10, startCol: 0, endCol: 1, col: 2, addrStartCol: -1, addrEndCol: -1, comma: ',', rangeFormat: "start-end", bits: 32}
+
+// newCsvReader reads r as described by format, tolerating rows of any width
+func newCsvReader(r io.Reader, format csvFormat) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.Comma = format.comma
+	cr.Comment = format.comment
+	// Don't let one short or long row abort the rest of the file
+	cr.FieldsPerRecord = -1
+	return cr
+}
+
+// maxCol returns the highest column index the format reads
+func (f csvFormat) maxCol() int {
+	return max(f.startCol, f.endCol, f.col, f.addrStartCol, f.addrEndCol)
+}
+
+// bounds reads the start and end of rec. Each is taken from its numeric
+// column when that holds a number, and otherwise parsed as an address from
+// that column or, failing that, from the address column. fromAddr reports
+// whether either bound was read as an address. With prefix set the end
+// column holds a prefix length instead, the end being the last address of
+// that prefix of start.
+func (f csvFormat) bounds(rec []string, prefix bool) (start, end *big.Int, fromAddr, ok bool) {
+	start, startAddr, ok := f.bound(rec, f.startCol, f.addrStartCol)
+	if !ok {
+		return nil, nil, false, false
+	}
+	if prefix {
+		end, ok := prefixEnd(start, rec[f.endCol], f.bits)
+		return start, end, startAddr, ok
+	}
+	end, endAddr, ok := f.bound(rec, f.endCol, f.addrEndCol)
+	if !ok {
+		return nil, nil, false, false
+	}
+	return start, end, startAddr || endAddr, true
+}
+
+func (f csvFormat) bound(rec []string, numCol, addrCol int) (n *big.Int, fromAddr, ok bool) {
+	if n, ok := parseRangeNum(rec[numCol], f.base); ok {
+		return n, false, true
+	}
+	for _, col := range []int{numCol, addrCol} {
+		if col < 0 || col >= len(rec) {
+			continue
+		}
+		if addr := net.ParseIP(strings.TrimSpace(rec[col])); addr != nil {
+			return ipToNum(addr), true, true
+		}
+	}
+	return nil, false, false
+}
+
+// prefixLength parses a prefix length of 0 to bits, optionally written
+// with a leading slash
+func prefixLength(raw string, bits int) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(raw), "/"))
+	return n, err == nil && n >= 0 && n <= bits
+}
+
+// prefixEnd returns the last address of the prefix of start whose length
+// is in raw, clearing any host bits of start as it goes, so the range is
+// the whole prefix
+func prefixEnd(start *big.Int, raw string, bits int) (*big.Int, bool) {
+	n, ok := prefixLength(raw, bits)
+	if !ok {
+		return nil, false
+	}
+	host := new(big.Int).Lsh(one, uint(bits-n))
+	host.Sub(host, one)
+	start.AndNot(start, host)
+	return new(big.Int).Or(start, host), true
+}
+
+// prefixRanges reports whether rec's end column holds a prefix length. In
+// auto mode that's when it holds a valid length written with a slash or
+// below the start, which an end can't be; decided is false when rec's
+// start doesn't parse, leaving it to a later row.
+func (f csvFormat) prefixRanges(rec []string) (prefix, decided bool) {
+	switch f.rangeFormat {
+	case "start-prefix":
+		return true, true
+	case "auto":
+		start, _, ok := f.bound(rec, f.startCol, f.addrStartCol)
+		if !ok {
+			return false, false
+		}
+		raw := strings.TrimSpace(rec[f.endCol])
+		n, ok := prefixLength(raw, f.bits)
+		return ok && (strings.HasPrefix(raw, "/") || start.Cmp(big.NewInt(int64(n))) > 0), true
+	}
+	return false, true
+}
+
+// csvCounts tallies the rows of a file readRangeCsv didn't add, and those
+// it added with a bound read as an address rather than a number. prefix
+// is whether the end column was read as prefix lengths.
+type csvCounts struct {
+	skipped  int
+	fromAddr int
+	prefix   bool
+}
+
+// rangeFormat names how a file's ends were read: "start-end" or
+// "start-prefix"
+func (n csvCounts) rangeFormat() string {
+	if n.prefix {
+		return "start-prefix"
+	}
+	return "start-end"
+}
+
+// representation names how a file's bounds were read: "numeric",
+// "address" or, when both occurred, "mixed"
+func (n csvCounts) representation(rows int) string {
+	switch {
+	case n.fromAddr == 0:
+		return "numeric"
+	case n.fromAddr == rows:
+		return "address"
+	}
+	return "mixed"
+}
+
+// readRangeCsv calls add for every row of the CSV at path whose start/end
+// columns, as described by format, hold numbers or addresses, the end not
+// before the start, and which has
+// at least minFields columns and every column format reads. Malformed rows
+// are skipped and counted rather than aborting the rest of the file, but
+// when the columns are customized and the first row is too narrow for them
+// the whole file is rejected, since the mapping can't be right.
+func readRangeCsv(path string, format csvFormat, minFields int, add func(start, end *big.Int, rec []string)) (counts csvCounts, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return counts, err
+	}
+	defer f.Close()
+
+	r := newCsvReader(f, format)
+	minFields = max(minFields, format.maxCol()+1)
+	custom := format.startCol != defaultCsvFormat.startCol || format.endCol != defaultCsvFormat.endCol || format.col != defaultCsvFormat.col ||
+		format.addrStartCol != defaultCsvFormat.addrStartCol || format.addrEndCol != defaultCsvFormat.addrEndCol
+	decided := false
+	for first := true; ; first = false {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				counts.skipped++
 				continue
 			}
-			end, ok := new(big.Int).SetString(rec[1], 10)
-			if !ok {
-				continue
+			return counts, err
+		}
+		if len(rec) < minFields {
+			if first && custom {
+				return counts, fmt.Errorf("column %d is out of range, the file has %d columns", format.maxCol(), len(rec))
 			}
-			arr = append(arr, IpAddressRange{start, end, rec[2]})
+			counts.skipped++
+			continue
+		}
+		if !decided {
+			counts.prefix, decided = format.prefixRanges(rec)
+		}
+		start, end, fromAddr, ok := format.bounds(rec, counts.prefix)
+		// A range ending before it starts covers nothing and would break
+		// everything sizing ranges
+		if !ok || end.Cmp(start) < 0 {
+			counts.skipped++
+			continue
+		}
+		if fromAddr {
+			counts.fromAddr++
 		}
+		add(start, end, rec)
 	}
+}
 
-	sort.Slice(arr, func(i, j int) bool {
-		return arr[i].start.Cmp(arr[j].start) < 0
-	})
-
-	return arr
+// parseRangeNum parses a range column in base 10 or 16, where hex may carry
+// a 0x prefix. Base 0 auto-detects per value: 0x-prefixed numbers are hex
+// and anything else is decimal.
+func parseRangeNum(s string, base int) (*big.Int, bool) {
+	hex, prefixed := strings.CutPrefix(strings.ToLower(s), "0x")
+	switch {
+	case base == 16 || (base == 0 && prefixed):
+		return new(big.Int).SetString(hex, 16)
+	default:
+		return new(big.Int).SetString(s, 10)
+	}
 }
 
 type IpAddress struct {
@@ -163,8 +613,13 @@ type IpAddress struct {
 	IpV6   bool    `json:"ip_v6"`
 }
 
+// ipValidator is shared by every lookup, since building one registers all
+// the baked-in validations and dominated the cost of a lookup. Validators
+// are safe for concurrent use.
+var ipValidator = validator.New()
+
 func parseIpAddress(rawIpAddr string) *IpAddress {
-	v := validator.New()
+	v := ipValidator
 
 	err := v.Var(rawIpAddr, "required,ip4_addr")
 	if err == nil {
@@ -181,49 +636,179 @@ type ApiResponse struct {
 	Ok      bool    `json:"ok"`
 	Country *string `json:"country"`
 	IpAddress
+	// Reserved is set, along with Category, for special-purpose addresses
+	// such as private, loopback or documentation ranges
+	Reserved bool    `json:"reserved,omitempty"`
+	Category *string `json:"category,omitempty"`
+	// Registry details, only under ?verbose=1 when RIR_CSV is configured
+	Rir            *string `json:"rir,omitempty"`
+	AllocationDate *string `json:"allocation_date,omitempty"`
+	// CountryName is the country's name in the language picked from
+	// Accept-Language, under ?verbose=1
+	CountryName *string `json:"country_name,omitempty"`
+	// Countries lists every country of the ranges containing the IP, the
+	// primary country first, under ?multi=1
+	Countries []string `json:"countries,omitempty"`
+	// Cidr lists the prefixes exactly covering the matched range, under
+	// ?verbose=1
+	Cidr []string `json:"cidr,omitempty"`
+	// RangeSize is the number of addresses in the matched range, under
+	// ?verbose=1. It is a string since IPv6 ranges overflow JSON numbers.
+	RangeSize *ipNumber `json:"range_size,omitempty"`
+	// Accuracy of the matched source, under ?verbose=1 when configured
+	Accuracy *string `json:"accuracy,omitempty"`
+	// Source is "fallback" when the country came from the fallback API
+	Source *string `json:"source,omitempty"`
+	// Hostname is the batch input when it was a hostname, resolved to
+	// ip_addr
+	Hostname *string `json:"hostname,omitempty"`
+	// AddressFamily tells an IPv4-mapped IPv6 input apart from plain IPv4,
+	// under ?verbose=1
+	AddressFamily *addressFamily `json:"address_family,omitempty"`
+	// Aggregate is the answer for the IPv6 prefix ip_addr falls in, under
+	// ?aggregate=N
+	Aggregate *aggregateInfo `json:"aggregate,omitempty"`
+	// Nearest is the gap a missed address falls in and the covered ranges
+	// around it, under ?nearest=1
+	Nearest *nearestInfo `json:"nearest,omitempty"`
+	// Ptr is the reverse DNS name of ip_addr, under ?ptr=1
+	Ptr *string `json:"ptr,omitempty"`
+	// Meta echoes the input and times the lookup, under ?verbose=1
+	Meta *lookupMeta `json:"meta,omitempty"`
+	// Extra holds the fields added by ENRICHERS, keyed by field name
+	Extra map[string]any `json:"extra,omitempty"`
+	// Error is the reason for ok:false: invalid_ip, reserved or not_found
+	Error string `json:"error,omitempty"`
+}
+
+// lookupMeta is the input as received, its canonical address form, null
+// for inputs that weren't addresses, and the server-side lookup duration
+type lookupMeta struct {
+	Input      string  `json:"input"`
+	Normalized *string `json:"normalized"`
+	DurationUs int64   `json:"duration_us"`
+}
+
+// addressFamily is the family an address was given in and the one it was
+// looked up in, which differ for IPv4-mapped IPv6 addresses
+type addressFamily struct {
+	Presented string `json:"presented"`
+	Lookup    string `json:"lookup"`
 }
 
 func main() {
-	if err := updateCsvFiles(); err != nil {
-		log.Fatalf("failed to update CSVs: %v", err)
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath, nil)
+	if err != nil {
+		slog.Error("invalid configuration", "err", err)
+		os.Exit(1)
+	}
+	setupLogger(cfg)
+	slog.Info("effective configuration", "config", cfg.redacted())
+
+	src := newDataSource(cfg)
+	var data *dataset
+	if !cfg.StartupGate {
+		if data, err = loadChain(cfg, src); err != nil {
+			slog.Error("failed to load data", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	var rirArr []rirRange
+	if cfg.RirCsv != "" {
+		rirArr = loadRirCsv(cfg.RirCsv)
+	}
+	var cityArr []cityRange
+	if cfg.CityCsv != "" {
+		cityArr = loadCityCsv(cfg.CityCsv)
 	}
 
-	arr := loadCsv()
+	var misses *missLogger
+	if cfg.LogMisses {
+		misses = newMissLogger(cfg.LogMissesSample, cfg.LogMissesMaxPrefixes)
+		go misses.run(missFlushInterval)
+	}
+
+	var fallback *fallbackClient
+	if cfg.FallbackURL != "" {
+		fallback = newFallbackClient(cfg)
+	}
+
+	var lookups *lookupCache
+	if cfg.RedisURL != "" {
+		if lookups, err = newLookupCache(cfg); err != nil {
+			slog.Error("failed to set up the lookup cache", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	enrichers, err := newEnrichers(cfg)
+	if err != nil {
+		slog.Error("failed to set up enrichers", "err", err)
+		os.Exit(1)
+	}
+
+	app := &app{cfg: cfg, configPath: *configPath, rirArr: rirArr, cityArr: cityArr, misses: misses, fallback: fallback,
+		lookups: lookups, enrichers: enrichers, lookupLatency: newHistogram(lookupBuckets), lookupCounts: newCounterVec(lookupResults)}
+	if data != nil {
+		app.data.Store(data)
+	}
 
 	r := gin.New()
-	r.Use(gin.Recovery())
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, recoverPanic(cfg)))
+	r.NoRoute(unknownEndpoint)
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"*"},
 		AllowHeaders:     []string{},
 		AllowCredentials: true,
 	}))
-
-	r.GET("/getIpInfo", func(c *gin.Context) {
-		ipAddr := parseIpAddress(c.Query("addr"))
-		if ipAddr != nil && ipAddr.IpAddr != nil {
-			addr := net.ParseIP(*ipAddr.IpAddr)
-			if addr != nil {
-				ipNum := big.NewInt(0)
-
-				if addr.To4() != nil {
-					ipNum = new(big.Int).SetUint64(uint64(binary.BigEndian.Uint32(addr.To4())))
-				} else {
-					ipNum.SetBytes(addr)
-				}
-
-				idx := sort.Search(len(arr), func(i int) bool {
-					return arr[i].start.Cmp(ipNum) > 0
-				})
-
-				if idx > 0 && arr[idx-1].end.Cmp(ipNum) >= 0 && ipNum.Sign() != 0 {
-					c.JSON(http.StatusOK, ApiResponse{Ok: true, Country: &arr[idx-1].country, IpAddress: *ipAddr})
-					return
+	app.routes(r)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// SIGHUP updates and reloads the data instead of stopping the process
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	// Everything that needs data starts once it has loaded, which with
+	// STARTUP_GATE happens while HTTP requests are already being answered
+	start := func() {
+		go app.watchStaleness(time.Hour)
+		app.logStartupSummary()
+		if cfg.AutoUpdateInterval > 0 {
+			go app.scheduleUpdates(ctx, cfg.AutoUpdateInterval, cfg.AutoUpdateJitter)
+		}
+		go app.reloadOnHangup(ctx, hup)
+		if cfg.TCPAddr != "" {
+			go func() {
+				if err := serveTCP(ctx, cfg, cfg.TCPAddr, app.resolver); err != nil {
+					slog.Error("line protocol server stopped", "err", err)
+					os.Exit(1)
 				}
-			}
+			}()
 		}
-		c.JSON(http.StatusOK, ApiResponse{Ok: false})
-	})
-
-	r.Run(":8080")
+	}
+	if cfg.StartupGate {
+		go func() {
+			data, err := app.loadFirst(ctx, cfg, src)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				slog.Error("failed to load data", "err", err)
+				os.Exit(1)
+			}
+			app.data.Store(data)
+			start()
+		}()
+	} else {
+		start()
+	}
+	if err := serve(ctx, cfg, newHTTPServer(cfg, r)); err != nil {
+		slog.Error("server stopped", "err", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes content to name in dir, returning its path
+func writeTestFile(t testing.TB, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rangeSpecs renders ranges as the "start-end:country" specs of testRanges
+func rangeSpecs(arr []IpAddressRange) string {
+	specs := make([]string, len(arr))
+	for i, r := range arr {
+		specs[i] = fmt.Sprintf("%s-%s:%s", r.start, r.end, r.country)
+	}
+	return strings.Join(specs, " ")
+}
+
+func TestReadRangeCsv(t *testing.T) {
+	hex := defaultCsvFormat
+	hex.base = 16
+	auto := defaultCsvFormat
+	auto.base = 0
+	semicolon := defaultCsvFormat
+	semicolon.comma, semicolon.comment = ';', '#'
+	columns := defaultCsvFormat
+	columns.startCol, columns.endCol, columns.col = 2, 3, 4
+	addrs := columns
+	addrs.addrStartCol, addrs.addrEndCol = 0, 1
+	prefix := defaultCsvFormat
+	prefix.rangeFormat, prefix.bits = "start-prefix", 32
+	autoPrefix := prefix
+	autoPrefix.rangeFormat = "auto"
+
+	tests := []struct {
+		name        string
+		format      csvFormat
+		content     string
+		want        string
+		wantSkipped int
+		wantErr     bool
+	}{
+		{
+			name:    "decimal",
+			format:  defaultCsvFormat,
+			content: "16777216,16777471,AU\n16777472,16778239,CN\n",
+			want:    "16777216-16777471:AU 16777472-16778239:CN",
+		},
+		{
+			name:        "malformed rows skipped and counted",
+			format:      defaultCsvFormat,
+			content:     "1,9,AA\nx,20,BB\n30,31\n60,6\"1,DD\n70,79,EE\n",
+			want:        "1-9:AA 70-79:EE",
+			wantSkipped: 3,
+		},
+		{
+			name:        "inverted row skipped",
+			format:      defaultCsvFormat,
+			content:     "200,100,US\n300,300,CA\n",
+			want:        "300-300:CA",
+			wantSkipped: 1,
+		},
+		{
+			name:    "hex with and without prefix",
+			format:  hex,
+			content: "0x1000000,0x10000ff,AU\n1000100,10003ff,CN\n",
+			want:    "16777216-16777471:AU 16777472-16778239:CN",
+		},
+		{
+			name:        "auto base",
+			format:      auto,
+			content:     "0x1000000,16777471,AU\nff,300,CN\n",
+			want:        "16777216-16777471:AU",
+			wantSkipped: 1,
+		},
+		{
+			name:    "semicolons and comments",
+			format:  semicolon,
+			content: "# start;end;country\n16777216;16777471;AU\n16777472;16778239;CN\n",
+			want:    "16777216-16777471:AU 16777472-16778239:CN",
+		},
+		{
+			name:    "custom columns",
+			format:  columns,
+			content: "x,y,1,9,AA\nx,y,10,19,BB\n",
+			want:    "1-9:AA 10-19:BB",
+		},
+		{
+			name:    "custom columns too narrow",
+			format:  columns,
+			content: "1,9,AA\n",
+			wantErr: true,
+		},
+		{
+			name:    "address columns",
+			format:  addrs,
+			content: "1.0.0.0,1.0.0.255,,,AU\n1.0.1.0,1.0.1.255,16777472,16777727,CN\n",
+			want:    "16777216-16777471:AU 16777472-16777727:CN",
+		},
+		{
+			name:        "prefix lengths",
+			format:      prefix,
+			content:     "16777216,24,AU\n16777473,/23,CN\n16777216,33,XX\n",
+			want:        "16777216-16777471:AU 16777216-16777727:CN",
+			wantSkipped: 1,
+		},
+		{
+			name:    "auto prefix lengths",
+			format:  autoPrefix,
+			content: "16777216,24,AU\n",
+			want:    "16777216-16777471:AU",
+		},
+		{
+			name:    "auto ends",
+			format:  autoPrefix,
+			content: "16,24,AU\n",
+			want:    "16-24:AU",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), "data.csv", tt.content)
+			var arr []IpAddressRange
+			counts, err := readRangeCsv(path, tt.format, 3, func(start, end *big.Int, rec []string) {
+				arr = append(arr, IpAddressRange{start: start, end: end, country: rec[tt.format.col]})
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readRangeCsv error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := rangeSpecs(arr); got != tt.want {
+				t.Errorf("ranges = %s, want %s", got, tt.want)
+			}
+			if counts.skipped != tt.wantSkipped {
+				t.Errorf("skipped = %d, want %d", counts.skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestSortAndDedupeRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []IpAddressRange
+		want string
+	}{
+		{name: "empty", in: nil, want: ""},
+		{
+			name: "unsorted",
+			in:   testRanges("20-29:BB", "10-19:AA", "10-15:CC"),
+			want: "10-15:CC 10-19:AA 20-29:BB",
+		},
+		{
+			name: "touching ranges kept",
+			in:   testRanges("10-19:AA", "20-29:AA"),
+			want: "10-19:AA 20-29:AA",
+		},
+		{
+			name: "duplicates dropped",
+			in:   testRanges("10-19:AA", "20-29:BB", "10-19:AA", "10-19:AA"),
+			want: "10-19:AA 20-29:BB",
+		},
+		{
+			name: "disagreeing duplicates kept in file order",
+			in:   testRanges("10-19:AA", "10-19:BB", "10-19:AA"),
+			want: "10-19:AA 10-19:BB",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sortRanges(tt.in)
+			if got := rangeSpecs(dedupeRanges(tt.in)); got != tt.want {
+				t.Errorf("sorted and deduped = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCsv(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.csv", "20,29,BB\n10,19,AA\nbad,row,XX\n")
+	writeTestFile(t, dir, "b.csv", "0x1e;0x27;CC\n")
+	cfg := &Config{
+		DataDir:    dir,
+		EnableIpv4: true,
+		EnableIpv6: true,
+		Files: []fileInfo{
+			{RemotePath: "src-a/a.csv", LocalName: "a.csv"},
+			{RemotePath: "src-b/b.csv", LocalName: "b.csv"},
+			{RemotePath: "src-c/c.csv", LocalName: "c.csv", IpV6: true},
+		},
+		NumberBase:      map[string]string{"src-b": "16"},
+		Delimiter:       map[string]string{"src-b": "semicolon"},
+		RequiredSources: map[string]bool{},
+	}
+
+	arr, statuses, err := loadCsv(cfg)
+	if err != nil {
+		t.Fatalf("loadCsv: %v", err)
+	}
+	if got, want := rangeSpecs(arr), "10-19:AA 20-29:BB 30-39:CC"; got != want {
+		t.Errorf("ranges = %s, want %s", got, want)
+	}
+	want := []struct {
+		loaded        bool
+		rows, skipped int
+	}{{true, 2, 1}, {true, 1, 0}, {false, 0, 0}}
+	for i, st := range statuses {
+		if st.Loaded != want[i].loaded || st.Rows != want[i].rows || st.Skipped != want[i].skipped {
+			t.Errorf("%s: loaded %v, rows %d, skipped %d, want %+v", st.File, st.Loaded, st.Rows, st.Skipped, want[i])
+		}
+	}
+	if statuses[2].Error == "" {
+		t.Error("missing c.csv has no error")
+	}
+
+	cfg.RequiredSources = map[string]bool{"src-c": true}
+	if _, _, err := loadCsv(cfg); err == nil {
+		t.Error("loadCsv succeeded without a required file")
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		start, raw string
+		bits       int
+		want       string // "start-end" after the call, "" when rejected
+	}{
+		{start: "16777216", raw: "24", bits: 32, want: "16777216-16777471"},
+		{start: "16777216", raw: "/32", bits: 32, want: "16777216-16777216"},
+		{start: "16777217", raw: "24", bits: 32, want: "16777216-16777471"},
+		{start: "0", raw: "0", bits: 32, want: "0-4294967295"},
+		{start: "16777216", raw: " 8 ", bits: 32, want: "16777216-33554431"},
+		{start: "16777216", raw: "33", bits: 32},
+		{start: "16777216", raw: "-1", bits: 32},
+		{start: "16777216", raw: "x", bits: 32},
+		{
+			start: "42540766411282592856903984951653826560", raw: "32", bits: 128,
+			want: "42540766411282592856903984951653826560-42540766490510755371168322545197776895",
+		},
+		{start: "42540766411282592856903984951653826560", raw: "129", bits: 128},
+	}
+	for _, tt := range tests {
+		start := bigNum(tt.start)
+		end, ok := prefixEnd(start, tt.raw, tt.bits)
+		got := ""
+		if ok {
+			got = start.String() + "-" + end.String()
+		}
+		if got != tt.want {
+			t.Errorf("prefixEnd(%s, %q, %d) = %q, want %q", tt.start, tt.raw, tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestParseRangeNum(t *testing.T) {
+	tests := []struct {
+		raw  string
+		base int
+		want string // "" when rejected
+	}{
+		{raw: "16777216", base: 10, want: "16777216"},
+		{raw: "0x1000000", base: 10},
+		{raw: "1000000", base: 16, want: "16777216"},
+		{raw: "0X1000000", base: 16, want: "16777216"},
+		{raw: "0x1000000", base: 0, want: "16777216"},
+		{raw: "1000000", base: 0, want: "1000000"},
+		{raw: "", base: 10},
+		{raw: "1.0.0.0", base: 10},
+	}
+	for _, tt := range tests {
+		got := ""
+		if n, ok := parseRangeNum(tt.raw, tt.base); ok {
+			got = n.String()
+		}
+		if got != tt.want {
+			t.Errorf("parseRangeNum(%q, %d) = %q, want %q", tt.raw, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestGitBlobSha(t *testing.T) {
+	// Expected values are what `git hash-object` prints for the same content
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{content: "", want: "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+		{content: "hello\n", want: "ce013625030ba8dba906f756967f9e9ca394464a"},
+		{content: "16777216,16777471,AU\n", want: "28df2ffed784308bbecfc88224ac9d069b483099"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "data.csv")
+		if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := gitBlobSha(path)
+		if err != nil {
+			t.Fatalf("gitBlobSha(%q): %v", tt.content, err)
+		}
+		if got != tt.want {
+			t.Errorf("gitBlobSha(%q) = %s, want %s", tt.content, got, tt.want)
+		}
+	}
+	if _, err := gitBlobSha(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("gitBlobSha of a missing file succeeded")
+	}
+}
+
+// BenchmarkLoadCsv loads an IPv4 file of 200k /24 ranges and an IPv6 file
+// of 100k /48s, in the sapics layout
+func BenchmarkLoadCsv(b *testing.B) {
+	dir := b.TempDir()
+	var v4, v6 strings.Builder
+	for _, r := range syntheticRanges(200_000, 0) {
+		fmt.Fprintf(&v4, "%s,%s,%s\n", r.start, r.end, r.country)
+	}
+	for _, r := range syntheticRanges(0, 100_000) {
+		fmt.Fprintf(&v6, "%s,%s,%s\n", r.start, r.end, r.country)
+	}
+	writeTestFile(b, dir, "ipv4.csv", v4.String())
+	writeTestFile(b, dir, "ipv6.csv", v6.String())
+	cfg := &Config{
+		DataDir:    dir,
+		EnableIpv4: true,
+		EnableIpv6: true,
+		Files: []fileInfo{
+			{RemotePath: "src/ipv4.csv", LocalName: "ipv4.csv"},
+			{RemotePath: "src/ipv6.csv", LocalName: "ipv6.csv", IpV6: true},
+		},
+	}
+
+	slog.SetLogLoggerLevel(slog.LevelWarn)
+	defer slog.SetLogLoggerLevel(slog.LevelInfo)
+	b.ReportAllocs()
+	for b.Loop() {
+		arr, _, err := loadCsv(cfg)
+		if err != nil || len(arr) != 300_000 {
+			b.Fatalf("loadCsv = %d ranges, %v", len(arr), err)
+		}
+	}
+}
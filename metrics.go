@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The /metrics exposition comes in two builds: the default one writes the
+// text format by hand, while building with -tags promhttp serves the same
+// metrics through the Prometheus client library, plus its Go runtime and
+// process collectors. Both read the state kept here.
+
+// metricLabelRe matches characters not allowed in a label name
+var metricLabelRe = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// buildInfoLabels returns the labels of the constant ipgeo_build_info
+// gauge: the build, and the SHA of each loaded data file as sha_<source>
+func (a *app) buildInfoLabels() (names, values []string) {
+	names = []string{"version", "commit", "go_version"}
+	values = []string{version, buildCommit(), runtime.Version()}
+	for _, st := range a.statuses() {
+		if st.SHA != "" {
+			names = append(names, "sha_"+metricLabelRe.ReplaceAllString(st.Source, "_"))
+			values = append(values, st.SHA)
+		}
+	}
+	return names, values
+}
+
+func (a *app) dataStaleGauge() float64 {
+	if a.dataStale() {
+		return 1
+	}
+	return 0
+}
+
+// lookupBuckets are the upper bounds, in seconds, of the lookup latency
+// histogram
+var lookupBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// exemplar links a histogram observation to the trace it happened in
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+// histogram is a cumulative Prometheus histogram that keeps the latest
+// exemplar of each bucket
+type histogram struct {
+	mu        sync.Mutex
+	bounds    []float64
+	counts    []uint64 // per bucket, plus +Inf at the end
+	exemplars []*exemplar
+	sum       float64
+	total     uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds:    bounds,
+		counts:    make([]uint64, len(bounds)+1),
+		exemplars: make([]*exemplar, len(bounds)+1),
+	}
+}
+
+// observe records v, attaching traceID as the bucket's exemplar if set
+func (h *histogram) observe(v float64, traceID string) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.total++
+	if traceID != "" {
+		h.exemplars[i] = &exemplar{traceID, v, time.Now()}
+	}
+}
+
+// histogramSnapshot is a consistent copy of a histogram. cumulative holds
+// the count of each bucket and all below it, +Inf last.
+type histogramSnapshot struct {
+	bounds     []float64
+	cumulative []uint64
+	exemplars  []*exemplar
+	sum        float64
+	total      uint64
+}
+
+func (h *histogram) snapshot() histogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := histogramSnapshot{
+		bounds:     h.bounds,
+		cumulative: make([]uint64, len(h.counts)),
+		exemplars:  append([]*exemplar(nil), h.exemplars...),
+		sum:        h.sum,
+		total:      h.total,
+	}
+	var cumulative uint64
+	for i, n := range h.counts {
+		cumulative += n
+		s.cumulative[i] = cumulative
+	}
+	return s
+}
+
+// lookupResults are the values of the result label of ipgeo_lookups_total:
+// a range match, a fallback answer, or the lookup error code
+var lookupResults = []string{"hit", "fallback", codeNotFound, codeReserved, codeInvalidIP, codeFamilyDisabled}
+
+// counterVec is a Prometheus counter with one label whose values are known
+// up front, so every series is exposed from zero
+type counterVec struct {
+	mu     sync.Mutex
+	values []string
+	counts map[string]uint64
+}
+
+func newCounterVec(values []string) *counterVec {
+	v := &counterVec{values: values, counts: map[string]uint64{}}
+	for _, value := range values {
+		v.counts[value] = 0
+	}
+	return v
+}
+
+func (v *counterVec) inc(value string) {
+	v.mu.Lock()
+	v.counts[value]++
+	v.mu.Unlock()
+}
+
+// snapshot returns the count of each label value, in the order given to
+// newCounterVec
+func (v *counterVec) snapshot() []uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	counts := make([]uint64, len(v.values))
+	for i, value := range v.values {
+		counts[i] = v.counts[value]
+	}
+	return counts
+}
+
+// countLookup records the outcome of one lookup in ipgeo_lookups_total
+func (a *app) countLookup(resp ApiResponse) {
+	switch {
+	case resp.Source != nil:
+		a.lookupCounts.inc("fallback")
+	case resp.Ok:
+		a.lookupCounts.inc("hit")
+	case resp.Error != "":
+		a.lookupCounts.inc(resp.Error)
+	}
+}
+
+// traceparentRe matches a W3C traceparent header, capturing the trace ID
+var traceparentRe = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// traceID returns the trace ID of the request's traceparent header, or ""
+func traceID(r *http.Request) string {
+	m := traceparentRe.FindStringSubmatch(strings.TrimSpace(r.Header.Get("traceparent")))
+	if m == nil || m[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	return m[1]
+}
+
+// timeLookups records the latency of lookup requests, with the caller's
+// trace ID as exemplar when METRICS_EXEMPLARS is enabled
+func (a *app) timeLookups(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	id := ""
+	if a.cfg.MetricsExemplars {
+		id = traceID(c.Request)
+	}
+	a.lookupLatency.observe(time.Since(start).Seconds(), id)
+}
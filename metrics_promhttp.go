@@ -0,0 +1,62 @@
+//go:build promhttp
+
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	dataAgeDesc = prometheus.NewDesc("ipgeo_data_age_seconds", "Seconds since the loaded data was last refreshed.", nil, nil)
+	staleDesc   = prometheus.NewDesc("ipgeo_data_stale", "1 if the loaded data is older than MAX_DATA_AGE.", nil, nil)
+	lookupsDesc = prometheus.NewDesc("ipgeo_lookups_total", "Lookups by result: hit, fallback or the lookup error code.", []string{"result"}, nil)
+	latencyDesc = prometheus.NewDesc("ipgeo_lookup_duration_seconds", "Latency of lookup requests.", nil, nil)
+)
+
+// appCollector exposes the app's metrics to the Prometheus registry. It is
+// unchecked, since the labels of ipgeo_build_info depend on the loaded files.
+type appCollector struct{ a *app }
+
+func (appCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (col appCollector) Collect(ch chan<- prometheus.Metric) {
+	a := col.a
+	ch <- prometheus.MustNewConstMetric(dataAgeDesc, prometheus.GaugeValue, a.dataAge().Seconds())
+	ch <- prometheus.MustNewConstMetric(staleDesc, prometheus.GaugeValue, a.dataStaleGauge())
+
+	names, values := a.buildInfoLabels()
+	buildInfo := prometheus.NewDesc("ipgeo_build_info", "Build and data provenance, always 1.", names, nil)
+	ch <- prometheus.MustNewConstMetric(buildInfo, prometheus.GaugeValue, 1, values...)
+
+	for i, n := range a.lookupCounts.snapshot() {
+		ch <- prometheus.MustNewConstMetric(lookupsDesc, prometheus.CounterValue, float64(n), lookupResults[i])
+	}
+
+	s := a.lookupLatency.snapshot()
+	buckets := make(map[float64]uint64, len(s.bounds))
+	var exemplars []prometheus.Exemplar
+	for i, bound := range s.bounds {
+		buckets[bound] = s.cumulative[i]
+	}
+	for _, e := range s.exemplars {
+		if e != nil {
+			exemplars = append(exemplars, prometheus.Exemplar{Value: e.value, Labels: prometheus.Labels{"trace_id": e.traceID}, Timestamp: e.at})
+		}
+	}
+	latency := prometheus.MustNewConstHistogram(latencyDesc, s.total, s.sum, buckets)
+	if len(exemplars) > 0 {
+		latency = prometheus.MustNewMetricWithExemplars(latency, exemplars...)
+	}
+	ch <- latency
+}
+
+// metrics serves the app's metrics together with the Go runtime and process
+// collectors through promhttp, in OpenMetrics when the scraper asks for it
+func (a *app) metrics(c *gin.Context) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(appCollector{a}, collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,88 @@
+//go:build !promhttp
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metrics serves the Prometheus text exposition, or OpenMetrics with trace
+// exemplars when the scraper asks for it
+func (a *app) metrics(c *gin.Context) {
+	openMetrics := strings.Contains(c.GetHeader("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		c.Header("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	writeFamily(w, "ipgeo_data_age_seconds", "gauge", "Seconds since the loaded data was last refreshed.")
+	fmt.Fprintf(w, "ipgeo_data_age_seconds %s\n", formatValue(a.dataAge().Seconds()))
+	writeFamily(w, "ipgeo_data_stale", "gauge", "1 if the loaded data is older than MAX_DATA_AGE.")
+	fmt.Fprintf(w, "ipgeo_data_stale %s\n", formatValue(a.dataStaleGauge()))
+
+	writeFamily(w, "ipgeo_build_info", "gauge", "Build and data provenance, always 1.")
+	fmt.Fprintf(w, "ipgeo_build_info{%s} 1\n", formatLabels(a.buildInfoLabels()))
+
+	// OpenMetrics names the counter family without the _total its sample
+	// carries, the older text format names both with it
+	family := "ipgeo_lookups_total"
+	if openMetrics {
+		family = "ipgeo_lookups"
+	}
+	writeFamily(w, family, "counter", "Lookups by result: hit, fallback or the lookup error code.")
+	for i, n := range a.lookupCounts.snapshot() {
+		fmt.Fprintf(w, "ipgeo_lookups_total{%s} %d\n", formatLabels([]string{"result"}, []string{lookupResults[i]}), n)
+	}
+
+	writeHistogram(w, "ipgeo_lookup_duration_seconds", "Latency of lookup requests.", a.lookupLatency.snapshot(), openMetrics)
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
+
+func writeFamily(w io.Writer, name, typ, help string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// formatLabels joins label pairs, escaping the values as both formats
+// require
+func formatLabels(names, values []string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i := range names {
+		pairs[i] = names[i] + `="` + escape.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeHistogram writes s in the Prometheus text format, or in OpenMetrics
+// with exemplars when openMetrics is set
+func writeHistogram(w io.Writer, name, help string, s histogramSnapshot, openMetrics bool) {
+	writeFamily(w, name, "histogram", help)
+	for i, cumulative := range s.cumulative {
+		le := "+Inf"
+		if i < len(s.bounds) {
+			le = formatValue(s.bounds[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d", name, le, cumulative)
+		if e := s.exemplars[i]; openMetrics && e != nil {
+			fmt.Fprintf(w, " # {%s} %s %.3f", formatLabels([]string{"trace_id"}, []string{e.traceID}), formatValue(e.value), float64(e.at.UnixMilli())/1000)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatValue(s.sum), name, s.total)
+}
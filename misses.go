@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	missFlushInterval = time.Minute
+	// missLogTopPrefixes limits how many of the busiest prefixes each flush
+	// lists in its log line. How many are tracked between flushes is
+	// maxPrefixes, set by LOG_MISSES_MAX_PREFIXES.
+	missLogTopPrefixes = 20
+)
+
+// missLogger aggregates valid IPs that matched no range, keyed by their
+// /24 (IPv4) or /48 (IPv6) prefix, and periodically logs the busiest
+// prefixes. The prefixes are what anonymizeIP would log, so they are safe to
+// log with ANONYMIZE_IPS too. Only one miss in every sample is counted, and
+// at most maxPrefixes prefixes between flushes, so a scan of unrouted space
+// can't grow the map without bound; misses of further prefixes are only
+// counted as overflow.
+type missLogger struct {
+	sample      int
+	maxPrefixes int
+	seen        atomic.Uint64
+
+	mu       sync.Mutex
+	counts   map[string]int
+	overflow int
+}
+
+func newMissLogger(sample, maxPrefixes int) *missLogger {
+	return &missLogger{sample: sample, maxPrefixes: maxPrefixes, counts: map[string]int{}}
+}
+
+// record counts a miss for the prefix containing addr, if it is sampled
+func (m *missLogger) record(addr net.IP) {
+	if m.sample > 1 && m.seen.Add(1)%uint64(m.sample) != 0 {
+		return
+	}
+	var prefix *net.IPNet
+	if v4 := addr.To4(); v4 != nil {
+		prefix = &net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+	} else {
+		prefix = &net.IPNet{IP: addr.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}
+	}
+
+	key := prefix.String()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.counts[key]; !ok && len(m.counts) >= m.maxPrefixes {
+		m.overflow++
+		return
+	}
+	m.counts[key]++
+}
+
+// run flushes the aggregated counts every interval until the process exits
+func (m *missLogger) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		m.flush()
+	}
+}
+
+func (m *missLogger) flush() {
+	m.mu.Lock()
+	counts, overflow := m.counts, m.overflow
+	m.counts, m.overflow = map[string]int{}, 0
+	m.mu.Unlock()
+
+	if len(counts) == 0 {
+		return
+	}
+
+	type prefixCount struct {
+		prefix string
+		count  int
+	}
+	total := 0
+	sorted := make([]prefixCount, 0, len(counts))
+	for p, n := range counts {
+		sorted = append(sorted, prefixCount{p, n})
+		total += n
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].prefix < sorted[j].prefix
+	})
+
+	// Counts are of sampled misses; overflow is the sampled misses of the
+	// prefixes past maxPrefixes, left out of total
+	slog.Debug("lookup misses", "total", total, "prefixes", len(sorted), "overflow", overflow, "sample", m.sample)
+	for i, pc := range sorted {
+		if i == missLogTopPrefixes {
+			break
+		}
+		slog.Debug("lookup miss prefix", "prefix", pc.prefix, "count", pc.count)
+	}
+}
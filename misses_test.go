@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestMissLoggerRecord(t *testing.T) {
+	tests := []struct {
+		name         string
+		sample, max  int
+		addrs        []string
+		wantCounts   map[string]int
+		wantOverflow int
+	}{
+		{
+			name:       "prefixes",
+			sample:     1,
+			max:        10,
+			addrs:      []string{"192.0.2.1", "192.0.2.200", "198.51.100.7", "2001:db8:1:2::1"},
+			wantCounts: map[string]int{"192.0.2.0/24": 2, "198.51.100.0/24": 1, "2001:db8:1::/48": 1},
+		},
+		{
+			name:       "sampled",
+			sample:     2,
+			max:        10,
+			addrs:      []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5"},
+			wantCounts: map[string]int{"192.0.2.0/24": 2},
+		},
+		{
+			name:         "overflow",
+			sample:       1,
+			max:          2,
+			addrs:        []string{"10.0.1.1", "10.0.2.1", "10.0.3.1", "10.0.1.2", "10.0.4.1"},
+			wantCounts:   map[string]int{"10.0.1.0/24": 2, "10.0.2.0/24": 1},
+			wantOverflow: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMissLogger(tt.sample, tt.max)
+			for _, raw := range tt.addrs {
+				m.record(net.ParseIP(raw))
+			}
+			if got, want := fmt.Sprint(m.counts), fmt.Sprint(tt.wantCounts); got != want {
+				t.Errorf("counts = %s, want %s", got, want)
+			}
+			if m.overflow != tt.wantOverflow {
+				t.Errorf("overflow = %d, want %d", m.overflow, tt.wantOverflow)
+			}
+			m.flush()
+			if len(m.counts) != 0 || m.overflow != 0 {
+				t.Errorf("flush left counts %v and overflow %d", m.counts, m.overflow)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wantsMsgpack reports whether the client asked for a msgpack response
+func wantsMsgpack(c *gin.Context) bool {
+	accept := c.GetHeader("Accept")
+	return strings.Contains(accept, "application/msgpack") || strings.Contains(accept, "application/x-msgpack")
+}
+
+// toMsgpack converts lookup results to the generic values encoded as
+// msgpack, or returns false for anything else, which stays JSON. It goes
+// through the JSON form, shaped by the request's options, so every field
+// is encoded under the same key and in the same form as in JSON.
+func toMsgpack(c *gin.Context, v any) (any, bool) {
+	switch v.(type) {
+	case ApiResponse, []ApiResponse:
+	default:
+		return nil, false
+	}
+	generic, err := toGeneric(shape(c, v))
+	if err != nil {
+		return nil, false
+	}
+	return msgpackNumbers(generic), true
+}
+
+// msgpackNumbers replaces the json.Numbers of a generic value with int64s
+// or float64s, for msgpack to encode as numbers rather than strings
+func msgpackNumbers(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]any:
+		for k, e := range t {
+			t[k] = msgpackNumbers(e)
+		}
+	case []any:
+		for i, e := range t {
+			t[i] = msgpackNumbers(e)
+		}
+	}
+	return v
+}
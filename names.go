@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// nameLanguages are the languages country names are available in. English
+// comes first, so it is what the matcher falls back to for "*".
+var nameLanguages = []language.Tag{
+	language.English, language.German, language.French, language.Spanish,
+	language.Italian, language.Portuguese, language.Dutch, language.Polish,
+	language.Russian, language.Turkish, language.Arabic, language.Hindi,
+	language.Chinese, language.Japanese, language.Korean,
+}
+
+var (
+	nameMatcher = language.NewMatcher(nameLanguages)
+	nameNamers  = make([]display.Namer, len(nameLanguages))
+)
+
+func init() {
+	for i, tag := range nameLanguages {
+		nameNamers[i] = display.Regions(tag)
+	}
+}
+
+// countryNamer picks the language for country names from an Accept-Language
+// header, honouring its q-values. Without a header names are in English; if
+// none of the accepted languages is available it returns nil, so names are
+// omitted rather than silently given in English.
+func countryNamer(acceptLanguage string) display.Namer {
+	if acceptLanguage == "" {
+		return nameNamers[0]
+	}
+	tags, wildcard := acceptedLanguages(acceptLanguage)
+	_, idx, confidence := nameMatcher.Match(tags...)
+	switch {
+	case confidence != language.No:
+		return nameNamers[idx]
+	case wildcard:
+		return nameNamers[0]
+	default:
+		return nil
+	}
+}
+
+// acceptedLanguages parses an Accept-Language header into its languages by
+// descending q-value, skipping entries it can't parse instead of rejecting
+// the whole header. wildcard reports whether "*" was accepted.
+func acceptedLanguages(header string) (tags []language.Tag, wildcard bool) {
+	type weighted struct {
+		tag language.Tag
+		q   float32
+	}
+	var accepted []weighted
+	for _, entry := range strings.Split(header, ",") {
+		entryTags, qs, err := language.ParseAcceptLanguage(entry)
+		if err != nil || len(entryTags) == 0 {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(entry), "*") {
+			wildcard = true
+			continue
+		}
+		accepted = append(accepted, weighted{entryTags[0], qs[0]})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	for _, w := range accepted {
+		tags = append(tags, w.tag)
+	}
+	return tags, wildcard
+}
+
+// countryName returns the name of the ISO 3166 country code in namer's
+// language, or nil if there is none
+func countryName(namer display.Namer, code string) *string {
+	if namer == nil {
+		return nil
+	}
+	region, err := language.ParseRegion(code)
+	if err != nil || !region.IsCountry() {
+		return nil
+	}
+	if name := namer.Name(region); name != "" {
+		return &name
+	}
+	return nil
+}
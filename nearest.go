@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nearestInfo places a missed address in its gap, under ?nearest=1
+type nearestInfo struct {
+	// Below and Above are the covered ranges on either side of the gap,
+	// null when the gap runs to the edge of the address space
+	Below *nearestRange `json:"below"`
+	Above *nearestRange `json:"above"`
+	// GapStart to GapEnd is the uncovered span the address falls in, and
+	// GapSize its number of addresses, a string like range_size
+	GapStart string    `json:"gap_start"`
+	GapEnd   string    `json:"gap_end"`
+	GapSize  *ipNumber `json:"gap_size"`
+}
+
+type nearestRange struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Country string `json:"country"`
+}
+
+// nearestRanges finds the covered ranges closest to ipNum, which no range
+// contains, within its address family. The binary search that found the
+// miss already places ipNum between arr[idx-1] and arr[idx]. Above is
+// arr[idx]; below is the range reaching furthest up before ipNum, which is
+// arr[idx-1] unless a wider range nests it, and maxEnd, arr's
+// runningMaxEnd, tells which.
+func nearestRanges(c *gin.Context, arr []IpAddressRange, maxEnd []*big.Int, ipNum *big.Int, v6 bool) *nearestInfo {
+	lo, hi := big.NewInt(0), maxIpv4Num
+	if v6 {
+		lo, hi = new(big.Int).Add(maxIpv4Num, one), maxIpv6Num
+	}
+	if ipNum.Cmp(lo) < 0 {
+		return nil
+	}
+	idx := sort.Search(len(arr), func(i int) bool {
+		return arr[i].start.Cmp(ipNum) > 0
+	})
+
+	info := &nearestInfo{}
+	gapStart, gapEnd := lo, hi
+	if idx > 0 && maxEnd[idx-1].Cmp(lo) >= 0 {
+		j := idx - 1
+		if end := maxEnd[j]; end.Cmp(ipNum) < 0 {
+			for arr[j].end.Cmp(end) != 0 {
+				j--
+			}
+		}
+		if below := &arr[j]; below.end.Cmp(lo) >= 0 {
+			info.Below = newNearestRange(below, v6)
+			gapStart = new(big.Int).Add(below.end, one)
+		}
+	}
+	if idx < len(arr) && arr[idx].start.Cmp(hi) <= 0 {
+		info.Above = newNearestRange(&arr[idx], v6)
+		gapEnd = new(big.Int).Sub(arr[idx].start, one)
+	}
+	info.GapStart = numToIp(gapStart, v6).String()
+	info.GapEnd = numToIp(gapEnd, v6).String()
+	size := new(big.Int).Sub(gapEnd, gapStart)
+	info.GapSize = newIpNumber(c, size.Add(size, one), v6)
+	return info
+}
+
+func newNearestRange(r *IpAddressRange, v6 bool) *nearestRange {
+	return &nearestRange{numToIp(r.start, v6).String(), numToIp(r.end, v6).String(), r.country}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// outboundHeaderTimeout bounds the wait for an upstream's response headers,
+// separately from OUTBOUND_TIMEOUT, which also covers reading large bodies
+const outboundHeaderTimeout = time.Minute
+
+// newOutboundClient builds the client of every download and upstream call.
+// It goes through the proxy of HTTPS_PROXY, HTTP_PROXY and NO_PROXY, trusts
+// the certificates of OUTBOUND_CA_FILE on top of the system's, and gives
+// up on requests after OUTBOUND_TIMEOUT.
+func newOutboundClient(cfg *Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.ResponseHeaderTimeout = outboundHeaderTimeout
+	if cfg.OutboundCAFile != "" {
+		pem, err := os.ReadFile(cfg.OutboundCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("OUTBOUND_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("OUTBOUND_CA_FILE: no PEM certificates found")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.OutboundTimeout}, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+
+	"Ip-geo-API/ipgeopb"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/proto"
+)
+
+// wantsProtobuf reports whether the client asked for a protobuf response
+func wantsProtobuf(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "application/x-protobuf")
+}
+
+// toProto converts lookup results to their protobuf message, or returns nil
+// for anything else, which stays JSON
+func toProto(v any) proto.Message {
+	switch t := v.(type) {
+	case ApiResponse:
+		return protoIpInfo(&t)
+	case []ApiResponse:
+		list := &ipgeopb.IpInfoList{Results: make([]*ipgeopb.IpInfo, len(t))}
+		for i := range t {
+			list.Results[i] = protoIpInfo(&t[i])
+		}
+		return list
+	}
+	return nil
+}
+
+func protoIpInfo(r *ApiResponse) *ipgeopb.IpInfo {
+	return &ipgeopb.IpInfo{
+		Ok:             r.Ok,
+		Country:        r.Country,
+		IpAddr:         r.IpAddr,
+		IpV6:           r.IpV6,
+		Reserved:       r.Reserved,
+		Category:       r.Category,
+		Rir:            r.Rir,
+		AllocationDate: r.AllocationDate,
+		CountryName:    r.CountryName,
+		Countries:      r.Countries,
+		Cidr:           r.Cidr,
+		RangeSize:      protoIpNumber(r.RangeSize),
+		Accuracy:       r.Accuracy,
+		Source:         r.Source,
+		Hostname:       r.Hostname,
+		Ptr:            r.Ptr,
+		Error:          r.Error,
+		AddressFamily:  protoAddressFamily(r.AddressFamily),
+		Aggregate:      protoAggregate(r.Aggregate),
+		Nearest:        protoNearest(r.Nearest),
+		Meta:           protoMeta(r.Meta),
+	}
+}
+
+func protoMeta(m *lookupMeta) *ipgeopb.LookupMeta {
+	if m == nil {
+		return nil
+	}
+	return &ipgeopb.LookupMeta{Input: m.Input, Normalized: m.Normalized, DurationUs: m.DurationUs}
+}
+
+func protoNearest(n *nearestInfo) *ipgeopb.Nearest {
+	if n == nil {
+		return nil
+	}
+	return &ipgeopb.Nearest{
+		Below:    protoNearestRange(n.Below),
+		Above:    protoNearestRange(n.Above),
+		GapStart: n.GapStart,
+		GapEnd:   n.GapEnd,
+		GapSize:  n.GapSize.String(),
+	}
+}
+
+func protoNearestRange(r *nearestRange) *ipgeopb.NearestRange {
+	if r == nil {
+		return nil
+	}
+	return &ipgeopb.NearestRange{Start: r.Start, End: r.End, Country: r.Country}
+}
+
+// protoIpNumber encodes n as the decimal string protobuf carries it as
+func protoIpNumber(n *ipNumber) *string {
+	if n == nil {
+		return nil
+	}
+	s := n.String()
+	return &s
+}
+
+func protoAggregate(a *aggregateInfo) *ipgeopb.Aggregate {
+	if a == nil {
+		return nil
+	}
+	return &ipgeopb.Aggregate{Prefix: a.Prefix, Country: a.Country, Share: a.Share}
+}
+
+func protoAddressFamily(f *addressFamily) *ipgeopb.AddressFamily {
+	if f == nil {
+		return nil
+	}
+	return &ipgeopb.AddressFamily{Presented: f.Presented, Lookup: f.Lookup}
+}
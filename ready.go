@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startupRetryInterval is how long STARTUP_GATE waits between attempts at
+// the first load
+const startupRetryInterval = 10 * time.Second
+
+type readyResponse struct {
+	Ready bool `json:"ready"`
+	// Fallback is set while the data served is the copy on disk that was
+	// loaded when STARTUP_TIMEOUT passed
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// ready tells orchestrators whether to route traffic here: it fails with
+// 503 until the first load succeeds, which only takes a while with
+// STARTUP_GATE, since the data is loaded before serving otherwise
+func (a *app) ready(c *gin.Context) {
+	data := a.current()
+	if data == nil {
+		c.JSON(http.StatusServiceUnavailable, readyResponse{})
+		return
+	}
+	c.JSON(http.StatusOK, readyResponse{Ready: true, Fallback: data.fallback})
+}
+
+// loadFirst runs the first LOAD_CHAIN for STARTUP_GATE, retrying until it
+// succeeds or StartupTimeout passes. The deadline is checked between
+// attempts, so a slow attempt can run past it. Once it passes, the files
+// already on disk are loaded without updating them if StartupTimeoutAction
+// is "fallback", and the last error is returned otherwise.
+func (a *app) loadFirst(ctx context.Context, cfg *Config, src dataSource) (*dataset, error) {
+	deadline := time.Now().Add(cfg.StartupTimeout)
+	var err error
+	for attempt := 1; ; attempt++ {
+		var data *dataset
+		a.updates.Lock()
+		data, err = loadChain(cfg, src)
+		a.updates.Unlock()
+		if err == nil {
+			return data, nil
+		}
+		wait := min(startupRetryInterval, time.Until(deadline))
+		if wait <= 0 {
+			break
+		}
+		slog.Warn("first data load failed, retrying", "attempt", attempt, "retry_in", wait.Round(time.Second), "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	if cfg.StartupTimeoutAction != "fallback" {
+		return nil, fmt.Errorf("no data loaded within STARTUP_TIMEOUT: %w", err)
+	}
+	slog.Warn("no data loaded within STARTUP_TIMEOUT, serving the files on disk", "err", err)
+	data, err := loadFromDisk(cfg, src, updateResult{}, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("loading fallback data: %w", err)
+	}
+	data.fallback = true
+	return data, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// redisMaxIdle bounds the connections kept open between commands
+	redisMaxIdle = 16
+	// redisMaxOpen bounds the connections open at once, idle or busy, so a
+	// slow server makes lookups wait for a connection, up to the timeout,
+	// rather than pile up new ones
+	redisMaxOpen = 64
+)
+
+// redisClient speaks just enough of the Redis protocol for the lookup
+// cache: GET, SET with an expiry and PING, over a small pool of
+// connections. Every command is bounded by the timeout, dial and the wait
+// for a connection included.
+type redisClient struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	timeout  time.Duration
+	idle     chan *redisConn
+	// open holds a token per open connection
+	open chan struct{}
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisURL is a parsed REDIS_URL:
+// redis://[[username]:password@]host[:port][/db], or rediss:// for TLS
+type redisURL struct {
+	addr, host         string
+	username, password string
+	db                 int
+	tls                bool
+}
+
+func parseRedisURL(raw string) (redisURL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redisURL{}, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return redisURL{}, fmt.Errorf("scheme must be redis or rediss, got %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return redisURL{}, errors.New("missing host")
+	}
+	parsed := redisURL{host: u.Hostname(), addr: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		parsed.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		parsed.username = u.User.Username()
+		parsed.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if parsed.db, err = strconv.Atoi(db); err != nil || parsed.db < 0 {
+			return redisURL{}, fmt.Errorf("database must be a number, got %q", db)
+		}
+	}
+	return parsed, nil
+}
+
+// newRedisClient connects lazily to REDIS_URL. A rediss:// server is
+// verified against the same CAs as other outbound requests.
+func newRedisClient(cfg *Config) (*redisClient, error) {
+	u, err := parseRedisURL(cfg.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	r := &redisClient{
+		addr:     u.addr,
+		username: u.username,
+		password: u.password,
+		db:       u.db,
+		timeout:  cfg.RedisTimeout,
+		idle:     make(chan *redisConn, redisMaxIdle),
+		open:     make(chan struct{}, redisMaxOpen),
+	}
+	if u.tls {
+		r.tls = &tls.Config{ServerName: u.host}
+		if t, ok := cfg.outbound.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+			r.tls = t.TLSClientConfig.Clone()
+			r.tls.ServerName = u.host
+		}
+	}
+	return r, nil
+}
+
+// get returns the value of key, and false if it isn't set
+func (r *redisClient) get(key string) (string, bool, error) {
+	reply, err := r.do("GET", key)
+	if err != nil || reply == nil {
+		return "", false, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return "", false, fmt.Errorf("redis: unexpected reply to GET: %v", reply)
+	}
+	return value, true, nil
+}
+
+// set sets key to value, expiring after ttl
+func (r *redisClient) set(key, value string, ttl time.Duration) error {
+	_, err := r.do("SET", key, value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (r *redisClient) ping() error {
+	_, err := r.do("PING")
+	return err
+}
+
+// do sends one command and reads its reply. A connection is only reused
+// after a complete exchange, so a timeout can't leave a stray reply behind
+// for the next command.
+func (r *redisClient) do(args ...string) (any, error) {
+	deadline := time.Now().Add(r.timeout)
+	conn, err := r.conn(deadline)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(deadline, args...)
+	if _, isReply := err.(redisError); err != nil && !isReply {
+		r.discard(conn)
+		return nil, err
+	}
+	select {
+	case r.idle <- conn:
+	default:
+		r.discard(conn)
+	}
+	return reply, err
+}
+
+// conn takes an idle connection, or dials a new one while fewer than
+// redisMaxOpen are open, waiting until deadline for either otherwise
+func (r *redisClient) conn(deadline time.Time) (*redisConn, error) {
+	select {
+	case conn := <-r.idle:
+		return conn, nil
+	default:
+	}
+	wait := time.NewTimer(time.Until(deadline))
+	defer wait.Stop()
+	select {
+	case conn := <-r.idle:
+		return conn, nil
+	case r.open <- struct{}{}:
+	case <-wait.C:
+		return nil, fmt.Errorf("redis: all %d connections busy", cap(r.open))
+	}
+	conn, err := r.dial(deadline)
+	if err != nil {
+		<-r.open
+	}
+	return conn, err
+}
+
+// discard closes conn, freeing its place for another
+func (r *redisClient) discard(conn *redisConn) {
+	conn.Close()
+	<-r.open
+}
+
+// dial connects to the server, authenticating and selecting the database
+func (r *redisClient) dial(deadline time.Time) (*redisConn, error) {
+	dialer := &net.Dialer{Deadline: deadline}
+	var nc net.Conn
+	var err error
+	if r.tls != nil {
+		nc, err = tls.DialWithDialer(dialer, "tcp", r.addr, r.tls)
+	} else {
+		nc, err = dialer.Dial("tcp", r.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	if r.password != "" {
+		auth := []string{"AUTH", r.password}
+		if r.username != "" {
+			auth = []string{"AUTH", r.username, r.password}
+		}
+		if _, err := conn.do(deadline, auth...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := conn.do(deadline, "SELECT", strconv.Itoa(r.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *redisConn) do(deadline time.Time, args ...string) (any, error) {
+	if err := c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply reads a simple string, error, integer or bulk string reply, a
+// null bulk string being nil. Arrays aren't needed by any command sent.
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/textproto"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reloadResult is the outcome of one reload. Unchanged is set when an
+// update found no changed file and the live dataset was kept as it was.
+type reloadResult struct {
+	Ok         bool     `json:"ok"`
+	Unchanged  bool     `json:"unchanged,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Ranges     int      `json:"ranges"`
+	Updated    []string `json:"updated,omitempty"`
+	DurationMs int64    `json:"duration_ms"`
+}
+
+type reloadCall struct {
+	done   chan struct{}
+	result reloadResult
+}
+
+// reloader coalesces concurrent reloads: while one is running, further
+// callers wait for it and share its result instead of starting another
+type reloader struct {
+	mu       sync.Mutex
+	inflight *reloadCall
+}
+
+// do runs fn unless a reload is already in flight, in which case it waits
+// for that one. joined reports whether the result came from another call.
+func (r *reloader) do(fn func() reloadResult) (result reloadResult, joined bool) {
+	r.mu.Lock()
+	if call := r.inflight; call != nil {
+		r.mu.Unlock()
+		<-call.done
+		return call.result, true
+	}
+	call := &reloadCall{done: make(chan struct{})}
+	r.inflight = call
+	r.mu.Unlock()
+
+	call.result = fn()
+
+	r.mu.Lock()
+	r.inflight = nil
+	r.mu.Unlock()
+	close(call.done)
+	return call.result, false
+}
+
+// reload loads the data from disk, first updating it when update is set,
+// and swaps the new dataset in only if it loaded successfully. An update
+// that changed no file keeps the current dataset when cfg is nil. It loads
+// with cfg, or the current dataset's settings when cfg is nil. Concurrent
+// calls share one reload.
+func (a *app) reload(trigger string, update bool, cfg *Config) (reloadResult, bool) {
+	return a.reloads.do(func() reloadResult {
+		start := time.Now()
+		slog.Info("reloading data", "trigger", trigger, "update", update)
+
+		current := a.current()
+		if cfg == nil {
+			cfg = current.cfg
+		}
+		src := newDataSource(cfg)
+		var (
+			data *dataset
+			res  updateResult
+			keep bool
+			err  error
+		)
+		if update {
+			a.updates.Lock()
+			res, err = updateData(cfg, src)
+			// Loading the same files with the same settings would only
+			// rebuild the live dataset, so keep that
+			keep = err == nil && cfg == current.cfg && len(res.Files) > 0 && len(res.Updated) == 0
+			if err == nil && !keep {
+				if data, err = loadFromDisk(cfg, src, res, start); err == nil {
+					data.loadedFrom = "network"
+				}
+			}
+			a.updates.Unlock()
+		} else {
+			data, err = loadFromDisk(cfg, src, res, start)
+		}
+		if keep {
+			a.data.Store(current.confirmedCurrent(res))
+			slog.Info("reload skipped, no data file changed", "trigger", trigger)
+			return reloadResult{Ok: true, Unchanged: true, Ranges: len(current.arr), DurationMs: time.Since(start).Milliseconds()}
+		}
+		if err != nil {
+			slog.Error("reload failed, keeping current data", "trigger", trigger, "err", err)
+			return reloadResult{Error: err.Error(), DurationMs: time.Since(start).Milliseconds()}
+		}
+		a.data.Store(data)
+		// Nothing references the previous dataset once in-flight lookups
+		// finish, but the last GC ran with both datasets live and set the
+		// next one for when the heap doubles again. Lookups allocate so
+		// little that it can take hours to get there, the process keeping
+		// both datasets' memory all that time. Reloads are rare, so force
+		// the collection now, which only blocks this goroutine, and hand
+		// the freed memory back to the OS.
+		debug.FreeOSMemory()
+		slog.Info("reload complete", "trigger", trigger, "ranges", len(data.arr))
+		return reloadResult{
+			Ok:         true,
+			Ranges:     len(data.arr),
+			Updated:    data.updated,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+	})
+}
+
+// requireData answers lookups with a 503 and Retry-After while there is no
+// dataset to serve, which STARTUP_GATE does until the first load succeeds.
+// Reloads never cause that: every backend loads the new generation next to
+// the live one and swaps it in atomically, so a lookup during a reload is
+// answered from the previous generation and never waits. A backend that
+// can't keep serving while it reloads must clear app.data for that time
+// rather than serve partial data.
+func (a *app) requireData(c *gin.Context) {
+	if a.current() == nil {
+		c.Header("Retry-After", "1")
+		respondError(c, http.StatusServiceUnavailable, codeUnavailable, "data isn't loaded yet, retry shortly")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// scheduleUpdates updates and reloads the data every interval until ctx is
+// cancelled. Each wait is randomly stretched or shrunk by up to jitter (a
+// fraction of interval) so instances started together spread their checks
+// out instead of hitting GitHub at once.
+func (a *app) scheduleUpdates(ctx context.Context, interval time.Duration, jitter float64) {
+	for {
+		wait := time.Duration(float64(interval) * (1 + jitter*(2*rand.Float64()-1)))
+		slog.Info("next scheduled update check", "at", time.Now().Add(wait).Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		a.reload("schedule", true, nil)
+	}
+}
+
+// reloadOnHangup updates and reloads the data on every SIGHUP on hup until
+// ctx is cancelled. The config is read again first, and the data settings
+// it changed apply to the new dataset; server settings such as the listen
+// address still need a restart. An invalid config is logged and the current
+// settings are kept.
+func (a *app) reloadOnHangup(ctx context.Context, hup <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		cfg, err := loadConfig(a.configPath, nil)
+		if err != nil {
+			slog.Error("SIGHUP: invalid configuration, reloading with the current settings", "err", err)
+			cfg = nil
+		}
+		result, joined := a.reload("sighup", true, cfg)
+		slog.Info("SIGHUP reload finished", "ok", result.Ok, "joined", joined, "ranges", result.Ranges,
+			"updated", result.Updated, "duration_ms", result.DurationMs, "err", result.Error)
+	}
+}
+
+// adminReload triggers a reload from disk, or with ?update=1 an update
+// followed by a reload, or joins the reload already running
+func (a *app) adminReload(c *gin.Context) {
+	result, joined := a.reload("admin", c.Query("update") == "1", nil)
+	if !result.Ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"ok":     false,
+			"error":  apiError{codeReloadFailed, result.Error},
+			"joined": joined,
+			"reload": result,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "joined": joined, "reload": result})
+}
+
+type updateResponse struct {
+	Ok         bool               `json:"ok"`
+	Error      *apiError          `json:"error,omitempty"`
+	Updated    []string           `json:"updated,omitempty"`
+	Pending    []string           `json:"pending,omitempty"`
+	Files      []fileUpdateStatus `json:"files"`
+	DurationMs int64              `json:"duration_ms"`
+}
+
+// adminUpdate fetches changed data files to disk without loading them, so
+// they can be swapped in later with a reload. Concurrent updates run one
+// after another.
+func (a *app) adminUpdate(c *gin.Context) {
+	start := time.Now()
+	a.updates.Lock()
+	cfg := a.current().cfg
+	update, err := updateData(cfg, newDataSource(cfg))
+	a.updates.Unlock()
+
+	resp := updateResponse{
+		Ok:         err == nil,
+		Updated:    update.Updated,
+		Pending:    update.Pending,
+		Files:      update.Files,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	status := http.StatusOK
+	if err != nil {
+		slog.Error("admin update failed", "err", err)
+		resp.Error = &apiError{codeUpdateFailed, err.Error()}
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, resp)
+}
+
+// requireHeader rejects requests that lack the header name, or whose value
+// isn't value when that is set, with a 403
+func requireHeader(name, value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got, found := c.Request.Header[textproto.CanonicalMIMEHeaderKey(name)]
+		if !found || (value != "" && subtle.ConstantTimeCompare([]byte(got[0]), []byte(value)) != 1) {
+			respondError(c, http.StatusForbidden, codeForbidden, fmt.Sprintf("missing or invalid %s header", name))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAdmin rejects requests without "Authorization: Bearer <ADMIN_TOKEN>"
+func requireAdmin(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			respondError(c, http.StatusUnauthorized, codeUnauthorized, "missing or invalid admin token")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
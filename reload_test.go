@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// procStatusKB reads a kB field such as VmRSS from /proc/self/status
+func procStatusKB(b *testing.B, field string) float64 {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		b.Skipf("reading RSS: %v", err)
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if rest, ok := strings.CutPrefix(line, field+":"); ok {
+			kb, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 64)
+			if err != nil {
+				b.Fatalf("parsing %s: %v", line, err)
+			}
+			return kb
+		}
+	}
+	b.Skipf("no %s in /proc/self/status", field)
+	return 0
+}
+
+// BenchmarkReloadMemory reloads 1M IPv4 /24 ranges next to a live copy of
+// the same and reports, in MB, the RSS with one dataset loaded, its peak
+// during the reload and the RSS once the reload returns. Peak RSS is read
+// from VmHWM after resetting it through /proc/self/clear_refs, so this
+// only runs on Linux.
+func BenchmarkReloadMemory(b *testing.B) {
+	if err := os.WriteFile("/proc/self/clear_refs", []byte("5"), 0); err != nil {
+		b.Skipf("can't reset peak RSS: %v", err)
+	}
+	dir := b.TempDir()
+	var v4 strings.Builder
+	for _, r := range syntheticRanges(1_000_000, 0) {
+		fmt.Fprintf(&v4, "%s,%s,%s\n", r.start, r.end, r.country)
+	}
+	writeTestFile(b, dir, "ipv4.csv", v4.String())
+	v4.Reset()
+	cfg := &Config{
+		DataDir:    dir,
+		EnableIpv4: true,
+		Files:      []fileInfo{{RemotePath: "src/ipv4.csv", LocalName: "ipv4.csv"}},
+	}
+
+	slog.SetLogLoggerLevel(slog.LevelWarn)
+	defer slog.SetLogLoggerLevel(slog.LevelInfo)
+	data, err := loadFromDisk(cfg, newDataSource(cfg), updateResult{}, time.Now())
+	if err != nil {
+		b.Fatal(err)
+	}
+	a := &app{cfg: cfg}
+	a.data.Store(data)
+	data = nil
+
+	var live, peak, after float64
+	for b.Loop() {
+		b.StopTimer()
+		a.reload("bench", false, nil)
+		live += procStatusKB(b, "VmRSS")
+		os.WriteFile("/proc/self/clear_refs", []byte("5"), 0)
+		b.StartTimer()
+		if result, _ := a.reload("bench", false, nil); !result.Ok || result.Ranges != 1_000_000 {
+			b.Fatalf("reload = %+v", result)
+		}
+		b.StopTimer()
+		peak += procStatusKB(b, "VmHWM")
+		after += procStatusKB(b, "VmRSS")
+		b.StartTimer()
+	}
+	n := float64(b.N) * 1024
+	b.ReportMetric(live/n, "live-MB")
+	b.ReportMetric(peak/n, "peak-MB")
+	b.ReportMetric(after/n, "after-MB")
+}
+
+func TestRequireData(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "geo-whois-asn-country-ipv4-num.csv", "16777216,16777471,AU\n")
+	updated := "16777216,16777471,JP\n"
+	updatedSha, err := gitBlobSha(writeTestFile(t, t.TempDir(), "updated.csv", updated))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake GitHub holds the update's metadata request until release is
+	// closed, keeping the reload in flight
+	requested, release := make(chan struct{}), make(chan struct{})
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/raw/") {
+			io.WriteString(w, updated)
+			return
+		}
+		close(requested)
+		<-release
+		json.NewEncoder(w).Encode(githubContent{SHA: updatedSha, Size: int64(len(updated))})
+	}))
+	defer github.Close()
+
+	cfg, err := loadConfig("", map[string]string{
+		"DATA_DIR":        dir,
+		"ENABLE_IPV6":     "false",
+		"AUTO_UPDATE":     "true",
+		"GITHUB_API_BASE": github.URL,
+		"GITHUB_RAW_BASE": github.URL + "/raw",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &app{cfg: cfg, lookupLatency: newHistogram(lookupBuckets), lookupCounts: newCounterVec(lookupResults)}
+	r := gin.New()
+	r.GET("/getIpInfo", a.requireData, a.getIpInfo)
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/getIpInfo?addr=1.0.0.1", nil))
+		return w
+	}
+	wantCountry := func(when, country string) {
+		t.Helper()
+		w := get()
+		var resp ApiResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || resp.Country == nil || *resp.Country != country {
+			t.Errorf("%s: status %d, body %s, want %s", when, w.Code, w.Body, country)
+		}
+	}
+
+	// Nothing is loaded yet, as under STARTUP_GATE before the first load
+	w := get()
+	var body errorResponse
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" || body.Error.Code != codeUnavailable {
+		t.Fatalf("without data: status %d, Retry-After %q, body %s, want 503, 1 and unavailable", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+
+	data, err := loadFromDisk(cfg, newDataSource(cfg), updateResult{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.data.Store(data)
+	wantCountry("after the first load", "AU")
+
+	done := make(chan reloadResult)
+	go func() {
+		result, _ := a.reload("test", true, nil)
+		done <- result
+	}()
+	<-requested
+	wantCountry("during the reload", "AU")
+	close(release)
+	if result := <-done; !result.Ok || len(result.Updated) != 1 {
+		t.Fatalf("reload = %+v, want the file updated", result)
+	}
+	wantCountry("after the reload", "JP")
+}
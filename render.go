@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// renderJSON writes v as the JSON response, shaped by the request's options.
+// Lookup results are written as protobuf instead when the client accepts
+// application/x-protobuf, or as msgpack when it accepts application/msgpack.
+func renderJSON(c *gin.Context, status int, v any) {
+	if wantsProtobuf(c) {
+		if msg := toProto(v); msg != nil {
+			c.ProtoBuf(status, msg)
+			return
+		}
+	}
+	if wantsMsgpack(c) {
+		if data, ok := toMsgpack(c, v); ok {
+			c.Render(status, render.MsgPack{Data: data})
+			return
+		}
+	}
+	c.JSON(status, shape(c, v))
+}
+
+// renderLookup writes a single lookup result, or with ?empty=204 answers a
+// valid address the data has no country for with 204 No Content instead
+func renderLookup(c *gin.Context, resp ApiResponse) {
+	if resp.Error == codeNotFound && c.Query("empty") == "204" {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	renderJSON(c, http.StatusOK, resp)
+}
+
+// shape applies the response-shaping options to v:
+//   - ?fields=a,b keeps only the listed top-level keys (plus "ok"); unknown
+//     names are ignored
+//   - ?case=camel, or the X-Response-Case: camel header, renames every key
+//     from snake_case to camelCase
+func shape(c *gin.Context, v any) any {
+	fields := parseFields(c.Query("fields"))
+	camel := wantsCamelCase(c)
+	if fields == nil && !camel {
+		return v
+	}
+
+	generic, err := toGeneric(v)
+	if err != nil {
+		return v
+	}
+	if fields != nil {
+		generic = filterFields(generic, fields)
+	}
+	if camel {
+		generic = camelizeKeys(generic)
+	}
+	return generic
+}
+
+func wantsCamelCase(c *gin.Context) bool {
+	if v := c.Query("case"); v != "" {
+		return v == "camel"
+	}
+	return strings.EqualFold(c.GetHeader("X-Response-Case"), "camel")
+}
+
+// ipNumber is a number from the IP space, such as a range size. It is
+// encoded as a JSON string, since IPv6 values exceed the 2^53 up to which
+// JSON parsers such as JavaScript's keep integers exact, except that IPv4
+// values are plain numbers for clients asking for ?ipv4_numbers=1.
+type ipNumber struct {
+	n        *big.Int
+	asNumber bool
+}
+
+// newIpNumber wraps n, a value of the IPv6 space if v6, for the request c
+func newIpNumber(c *gin.Context, n *big.Int, v6 bool) *ipNumber {
+	return &ipNumber{n: n, asNumber: !v6 && wantsIpv4Numbers(c)}
+}
+
+func (n *ipNumber) String() string {
+	return n.n.String()
+}
+
+func (n *ipNumber) MarshalJSON() ([]byte, error) {
+	if n.asNumber {
+		return []byte(n.n.String()), nil
+	}
+	return json.Marshal(n.n.String())
+}
+
+// wantsIpv4Numbers reports whether the client asked for IPv4 values of
+// ipNumber fields as JSON numbers
+func wantsIpv4Numbers(c *gin.Context) bool {
+	v := c.Query("ipv4_numbers")
+	return v == "1" || v == "true"
+}
+
+// isVerbose reports whether the client asked for extended fields
+func isVerbose(c *gin.Context) bool {
+	v := c.Query("verbose")
+	return v == "1" || v == "true"
+}
+
+func parseFields(raw string) map[string]bool {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	fields := map[string]bool{"ok": true}
+	for _, f := range strings.Split(raw, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			// Accept names in either case style
+			fields[snakeCase(f)] = true
+		}
+	}
+	return fields
+}
+
+// toGeneric round-trips v through JSON so it can be reshaped as maps and
+// slices. Numbers are kept as json.Number to avoid float rounding.
+func toGeneric(v any) (any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
+
+// filterFields keeps only the wanted keys of an object, or of each object
+// in an array
+func filterFields(v any, fields map[string]bool) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k := range t {
+			if !fields[k] {
+				delete(t, k)
+			}
+		}
+	case []any:
+		for i := range t {
+			t[i] = filterFields(t[i], fields)
+		}
+	}
+	return v
+}
+
+// camelizeKeys renames the keys of every object in v to camelCase
+func camelizeKeys(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, val := range t {
+			out[camelCase(k)] = camelizeKeys(val)
+		}
+		return out
+	case []any:
+		for i := range t {
+			t[i] = camelizeKeys(t[i])
+		}
+	}
+	return v
+}
+
+// camelCase converts "ip_addr" to "ipAddr"
+func camelCase(s string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range s {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// snakeCase converts "ipAddr" to "ip_addr"
+func snakeCase(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('_')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewIpNumber(t *testing.T) {
+	tests := []struct {
+		query string
+		n     string
+		v6    bool
+		want  string
+	}{
+		{query: "", n: "256", want: `"256"`},
+		{query: "ipv4_numbers=1", n: "256", want: `256`},
+		{query: "ipv4_numbers=true", n: "4294967295", want: `4294967295`},
+		{query: "ipv4_numbers=0", n: "256", want: `"256"`},
+		{query: "", n: "1208925819614629174706176", v6: true, want: `"1208925819614629174706176"`},
+		// Past 2^53 a JSON number would be rounded, so IPv6 stays a string
+		{query: "ipv4_numbers=1", n: "1208925819614629174706176", v6: true, want: `"1208925819614629174706176"`},
+		{query: "ipv4_numbers=1", n: "1", v6: true, want: `"1"`},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/getIpInfo?"+tt.query, nil)
+		got, err := json.Marshal(newIpNumber(c, bigNum(tt.n), tt.v6))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("newIpNumber(%s, v6 %v) with %q = %s, %v, want %s", tt.n, tt.v6, tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestRangeSizeEncoding(t *testing.T) {
+	a := newTestApp(t, "16777216-16777471:AU", "55827987809411540836515382960316219392-55827987809412749762334997589490925567:IE")
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "/getIpInfo?addr=1.0.0.1&verbose=1", want: `"256"`},
+		{target: "/getIpInfo?addr=1.0.0.1&verbose=1&ipv4_numbers=1", want: `256`},
+		{target: "/getIpInfo?addr=2a00:1450::1&verbose=1", want: `"1208925819614629174706176"`},
+		{target: "/getIpInfo?addr=2a00:1450::1&verbose=1&ipv4_numbers=1", want: `"1208925819614629174706176"`},
+	}
+	for _, tt := range tests {
+		w := serveTest(a.getIpInfo, httptest.NewRequest("GET", tt.target, nil))
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v: %s", tt.target, err, w.Body)
+		}
+		if got := string(body["range_size"]); got != tt.want {
+			t.Errorf("%s: range_size = %s, want %s", tt.target, got, tt.want)
+		}
+	}
+}
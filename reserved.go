@@ -0,0 +1,75 @@
+package main
+
+import "net"
+
+type reservedRange struct {
+	prefix   *net.IPNet
+	category string
+}
+
+// reservedRanges lists the IANA special-purpose IPv4 and IPv6 blocks. These
+// are never geolocated; lookups report their category instead.
+var reservedRanges = mustReservedRanges([][2]string{
+	// IPv4 (RFC 6890 and updates)
+	{"0.0.0.0/8", "this-network"},
+	{"10.0.0.0/8", "private"},
+	{"100.64.0.0/10", "shared"},
+	{"127.0.0.0/8", "loopback"},
+	{"169.254.0.0/16", "link-local"},
+	{"172.16.0.0/12", "private"},
+	{"192.0.0.0/24", "ietf-protocol"},
+	{"192.0.2.0/24", "documentation"},
+	{"192.88.99.0/24", "6to4-relay"},
+	{"192.168.0.0/16", "private"},
+	{"198.18.0.0/15", "benchmarking"},
+	{"198.51.100.0/24", "documentation"},
+	{"203.0.113.0/24", "documentation"},
+	{"224.0.0.0/4", "multicast"},
+	{"240.0.0.0/4", "reserved"},
+	{"255.255.255.255/32", "broadcast"},
+
+	// IPv6
+	{"::/128", "unspecified"},
+	{"::1/128", "loopback"},
+	{"64:ff9b::/96", "nat64"},
+	{"64:ff9b:1::/48", "nat64"},
+	{"100::/64", "discard"},
+	{"2001::/32", "teredo"},
+	{"2001:2::/48", "benchmarking"},
+	{"2001:10::/28", "orchid"},
+	{"2001:20::/28", "orchid"},
+	{"2001:db8::/32", "documentation"},
+	{"2002::/16", "6to4"},
+	{"3fff::/20", "documentation"},
+	{"fc00::/7", "unique-local"},
+	{"fe80::/10", "link-local"},
+	{"ff00::/8", "multicast"},
+})
+
+func mustReservedRanges(defs [][2]string) []reservedRange {
+	ranges := make([]reservedRange, 0, len(defs))
+	for _, d := range defs {
+		_, prefix, err := net.ParseCIDR(d[0])
+		if err != nil {
+			panic(err)
+		}
+		ranges = append(ranges, reservedRange{prefix, d[1]})
+	}
+	return ranges
+}
+
+// reservedCategory returns the special-purpose category of addr, or "" if
+// addr is a regular global address
+func reservedCategory(addr net.IP) string {
+	for _, r := range reservedRanges {
+		// Keep IPv4 and IPv6 blocks apart so that e.g. ::/128 doesn't
+		// match an IPv4 address through its mapped form
+		if (r.prefix.IP.To4() != nil) != (addr.To4() != nil) {
+			continue
+		}
+		if r.prefix.Contains(addr) {
+			return r.category
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReservedCategory(t *testing.T) {
+	// Every address is covered, so only the reserved check keeps the
+	// special-purpose ones from getting a country
+	arr := testRanges("0-4294967295:US", "4294967296-340282366920938463463374607431768211455:DE")
+	tests := []struct {
+		addr string
+		want string // category, "" for a global address
+	}{
+		{addr: "2001:db8::1", want: "documentation"},
+		{addr: "fe80::1", want: "link-local"},
+		{addr: "2001::1", want: "teredo"},
+		{addr: "2002:c000:204::1", want: "6to4"},
+		{addr: "::1", want: "loopback"},
+		{addr: "2a00:1450::1"},
+		{addr: "192.0.2.1", want: "documentation"},
+		{addr: "169.254.1.1", want: "link-local"},
+		{addr: "8.8.8.8"},
+		// A mapped address is IPv4, so the IPv4 blocks apply and ::/128
+		// doesn't match 0.0.0.1 through its mapped form
+		{addr: "::ffff:192.0.2.1", want: "documentation"},
+		{addr: "::ffff:0.0.0.1", want: "this-network"},
+		{addr: "::ffff:8.8.8.8"},
+		{addr: "::", want: "unspecified"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := reservedCategory(net.ParseIP(tt.addr)); got != tt.want {
+				t.Errorf("reservedCategory(%s) = %q, want %q", tt.addr, got, tt.want)
+			}
+
+			resp, _ := lookup(arr, tt.addr)
+			if tt.want == "" {
+				if !resp.Ok || resp.Reserved {
+					t.Errorf("lookup(%s) = %+v, want a match", tt.addr, resp)
+				}
+				return
+			}
+			if resp.Ok || !resp.Reserved || resp.Category == nil || *resp.Category != tt.want || resp.Error != codeReserved || resp.Country != nil {
+				t.Errorf("lookup(%s) = %+v, want reserved %s without a country", tt.addr, resp, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log/slog"
+	"math/big"
+	"sort"
+)
+
+// rirRange is a block from the optional registry dataset
+type rirRange struct {
+	start          *big.Int
+	end            *big.Int
+	rir            string
+	allocationDate string
+}
+
+// loadRirCsv reads the registry CSV at path, whose rows are
+// start_num,end_num,rir,allocation_date, and returns sorted ranges
+func loadRirCsv(path string) []rirRange {
+	arr := []rirRange{}
+	counts, err := readRangeCsv(path, defaultCsvFormat, 4, func(start, end *big.Int, rec []string) {
+		arr = append(arr, rirRange{start, end, rec[2], rec[3]})
+	})
+	if err != nil {
+		slog.Error("reading registry file", "file", path, "err", err)
+	}
+	if counts.skipped > 0 {
+		slog.Warn("skipped malformed rows", "file", path, "rows", counts.skipped)
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].start.Cmp(arr[j].start) < 0
+	})
+	return arr
+}
+
+// findRirRange returns the registry block containing ipNum, or nil
+func findRirRange(arr []rirRange, ipNum *big.Int) *rirRange {
+	idx := sort.Search(len(arr), func(i int) bool {
+		return arr[i].start.Cmp(ipNum) > 0
+	})
+	if idx > 0 && arr[idx-1].end.Cmp(ipNum) >= 0 {
+		return &arr[idx-1]
+	}
+	return nil
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	maxIpv4Num = new(big.Int).SetUint64(1<<32 - 1)
+	maxIpv6Num = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+	one        = big.NewInt(1)
+)
+
+// segmentsCheckEvery is how many segments walkSegments produces between
+// checks for a cancelled request
+const segmentsCheckEvery = 256
+
+// rangeSegment is a piece of a span that resolves to a single country, or
+// to none (Country is null) where the dataset has a gap
+type rangeSegment struct {
+	Start   string  `json:"start"`
+	End     string  `json:"end"`
+	Country *string `json:"country"`
+}
+
+// numToIp converts an IP number back to its address form
+func numToIp(n *big.Int, v6 bool) net.IP {
+	if v6 {
+		return n.FillBytes(make(net.IP, net.IPv6len))
+	}
+	b := n.FillBytes(make([]byte, net.IPv4len))
+	return net.IPv4(b[0], b[1], b[2], b[3])
+}
+
+// parseIpOrNum accepts an IP address or its decimal number. family is 4 or
+// 6 for addresses and 0 for numbers, whose family is ambiguous.
+func parseIpOrNum(raw string) (n *big.Int, family int, ok bool) {
+	if addr := net.ParseIP(raw); addr != nil {
+		if addr.To4() != nil {
+			return ipToNum(addr), 4, true
+		}
+		return ipToNum(addr), 6, true
+	}
+	n, ok = new(big.Int).SetString(raw, 10)
+	if !ok || n.Sign() < 0 || n.Cmp(maxIpv6Num) > 0 {
+		return nil, 0, false
+	}
+	return n, 0, true
+}
+
+// spanFamily works out whether [start, end] is an IPv4 or IPv6 span from
+// the families parseIpOrNum reported. Numbers take the family of the other
+// end when it is an address, and are otherwise IPv6 only if they exceed
+// the IPv4 space.
+func spanFamily(start, end *big.Int, startFamily, endFamily int) (v6 bool, err error) {
+	if startFamily != 0 && endFamily != 0 && startFamily != endFamily {
+		return false, errors.New("start and end must be the same address family")
+	}
+	family := max(startFamily, endFamily)
+	if family == 0 {
+		family = 4
+		if end.Cmp(maxIpv4Num) > 0 {
+			family = 6
+		}
+	}
+	if family == 4 && end.Cmp(maxIpv4Num) > 0 {
+		return false, errors.New("end is outside the IPv4 address space")
+	}
+	return family == 6, nil
+}
+
+// walkSegments splits [start, end] into consecutive segments following the
+// same precedence as findRange, merging neighbours with the same answer. It
+// stops early, with the segments so far, once ctx is done. With a positive
+// limit it stops after that many segments, returning where the rest starts
+// as next. An IPv6 span is a gap up to maxIpv4Num, whose numbers lookups
+// never resolve for IPv6 addresses, and is walked from there on only, as
+// computeCoverage does.
+func walkSegments(ctx context.Context, arr []IpAddressRange, start, end *big.Int, v6 bool, limit int) (segments []rangeSegment, next *big.Int) {
+	segments = []rangeSegment{}
+	add := func(cur, segEnd *big.Int, country *string) bool {
+		last := len(segments) - 1
+		if last >= 0 && sameCountry(segments[last].Country, country) {
+			segments[last].End = numToIp(segEnd, v6).String()
+			return true
+		}
+		if limit > 0 && len(segments) == limit {
+			next = new(big.Int).Set(cur)
+			return false
+		}
+		segments = append(segments, rangeSegment{
+			Start:   numToIp(cur, v6).String(),
+			End:     numToIp(segEnd, v6).String(),
+			Country: country,
+		})
+		return true
+	}
+	if v6 && start.Cmp(maxIpv4Num) <= 0 {
+		gapEnd := new(big.Int).Set(maxIpv4Num)
+		if end.Cmp(gapEnd) < 0 {
+			gapEnd.Set(end)
+		}
+		if !add(start, gapEnd, nil) {
+			return segments, next
+		}
+		start = new(big.Int).Add(maxIpv4Num, one)
+	}
+	eachSegment(ctx, arr, start, end, add)
+	return segments, next
+}
+
+// eachSegment calls fn for consecutive pieces of [start, end] that each
+// resolve to a single country, or to none (nil) in a gap, in order, until
+// fn returns false. fn must not keep start and end, which are reused. It
+// stops early once ctx is done.
+func eachSegment(ctx context.Context, arr []IpAddressRange, start, end *big.Int, fn func(start, end *big.Int, country *string) bool) {
+	cur := new(big.Int).Set(start)
+	for n := 0; cur.Cmp(end) <= 0; n++ {
+		if n%segmentsCheckEvery == 0 && ctx.Err() != nil {
+			break
+		}
+		// The range that owns cur, if any, is the last one starting at or
+		// before it; the next range to start after cur may take over
+		idx := sort.Search(len(arr), func(i int) bool {
+			return arr[i].start.Cmp(cur) > 0
+		})
+		segEnd := new(big.Int).Set(end)
+		if idx < len(arr) {
+			if beforeNext := new(big.Int).Sub(arr[idx].start, one); beforeNext.Cmp(segEnd) < 0 {
+				segEnd = beforeNext
+			}
+		}
+		var country *string
+		if idx > 0 && arr[idx-1].end.Cmp(cur) >= 0 {
+			country = &arr[idx-1].country
+			if arr[idx-1].end.Cmp(segEnd) < 0 {
+				segEnd = new(big.Int).Set(arr[idx-1].end)
+			}
+		}
+		if !fn(cur, segEnd, country) {
+			return
+		}
+		cur = segEnd.Add(segEnd, one)
+	}
+}
+
+// segments is walkSegments over data, with gaps labelled
+// UNKNOWN_COUNTRY_CODE when it is set
+func (a *app) segments(ctx context.Context, data *dataset, start, end *big.Int, v6 bool, limit int) ([]rangeSegment, *big.Int) {
+	segments, next := walkSegments(ctx, data.arr, start, end, v6, limit)
+	for i := range segments {
+		segments[i].Country = a.countryOrUnknown(segments[i].Country)
+	}
+	return segments, next
+}
+
+// nextPage returns the request's own URL with the query parameter param
+// set to value, the link to the next page of a truncated result
+func nextPage(c *gin.Context, param, value string) string {
+	q := c.Request.URL.Query()
+	q.Set(param, value)
+	u := *c.Request.URL
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+func sameCountry(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// rangeInfo breaks down the arbitrary span ?start=A&end=B (addresses or
+// numbers) into per-country segments. Past MAX_RESULT_ROWS segments the
+// response is truncated, with a link to the rest.
+func (a *app) rangeInfo(c *gin.Context) {
+	start, startFamily, ok1 := parseIpOrNum(c.Query("start"))
+	end, endFamily, ok2 := parseIpOrNum(c.Query("end"))
+	if !ok1 || !ok2 {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "start and end must be IP addresses or IP numbers")
+		return
+	}
+	v6, err := spanFamily(start, end, startFamily, endFamily)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if start.Cmp(end) > 0 {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "start must not be greater than end")
+		return
+	}
+	if v6 && new(big.Int).Sub(end, start).BitLen() > a.cfg.MaxIpv6SpanBits {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("IPv6 spans are limited to 2^%d addresses", a.cfg.MaxIpv6SpanBits))
+		return
+	}
+
+	segments, next := a.segments(c.Request.Context(), a.current(), start, end, v6, a.cfg.maxResultRows("rangeInfo"))
+	if requestDone(c) {
+		respondDone(c)
+		return
+	}
+	resp := gin.H{"ok": true, "segments": segments}
+	if next != nil {
+		resp["truncated"] = true
+		resp["next"] = nextPage(c, "start", numToIp(next, v6).String())
+	}
+	renderJSON(c, http.StatusOK, resp)
+}
+
+// parseCidr validates raw as an IPv4 or IPv6 prefix and returns it in
+// canonical form (host bits cleared, IPv4-mapped IPv6 prefixes unmapped).
+// With strict set, a prefix with host bits set is rejected instead.
+func parseCidr(raw string, strict bool) (netip.Prefix, error) {
+	// ParsePrefix rejects zones as malformed, so catch them first to say
+	// what is wrong
+	if strings.Contains(raw, "%") {
+		return netip.Prefix{}, errors.New("CIDR prefixes can't have a zone")
+	}
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(raw))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("malformed CIDR prefix %q", raw)
+	}
+	if prefix.Addr().Is4In6() {
+		if prefix.Bits() < 96 {
+			return netip.Prefix{}, errors.New("IPv4-mapped prefixes must be at least /96")
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	if strict && prefix.Masked() != prefix {
+		return netip.Prefix{}, fmt.Errorf("%s has host bits set, did you mean %s?", prefix, prefix.Masked())
+	}
+	return prefix.Masked(), nil
+}
+
+// prefixSpan returns the first and last IP numbers covered by prefix
+func prefixSpan(prefix netip.Prefix) (start, end *big.Int) {
+	start = new(big.Int).SetBytes(prefix.Addr().AsSlice())
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	size := new(big.Int).Lsh(one, uint(hostBits))
+	end = size.Add(start, size).Sub(size, one)
+	return start, end
+}
+
+// rangeToCidrs returns the minimal list of prefixes exactly covering
+// [start, end]: a prefix-aligned range yields one prefix, any other range
+// the largest aligned blocks that fit, in order
+func rangeToCidrs(start, end *big.Int, v6 bool) []string {
+	bits := 32
+	if v6 {
+		bits = 128
+	}
+	cidrs := []string{}
+	cur := new(big.Int).Set(start)
+	for cur.Cmp(end) <= 0 {
+		// The block can be no larger than cur's alignment allows, and must
+		// not run past end
+		hostBits := bits
+		if cur.Sign() != 0 {
+			hostBits = min(int(cur.TrailingZeroBits()), bits)
+		}
+		remaining := new(big.Int).Sub(end, cur)
+		remaining.Add(remaining, one)
+		for hostBits > 0 && new(big.Int).Lsh(one, uint(hostBits)).Cmp(remaining) > 0 {
+			hostBits--
+		}
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", numToIp(cur, v6), bits-hostBits))
+		cur.Add(cur, new(big.Int).Lsh(one, uint(hostBits)))
+	}
+	return cidrs
+}
+
+// cidrResult is the breakdown of one prefix. Cidr is the canonical prefix,
+// or the input as given when it was rejected.
+type cidrResult struct {
+	Ok       bool           `json:"ok"`
+	Cidr     string         `json:"cidr"`
+	Segments []rangeSegment `json:"segments,omitempty"`
+	// Truncated is set when /getCidrInfo stopped at MAX_RESULT_ROWS
+	// segments, and Next links to the rest
+	Truncated bool   `json:"truncated,omitempty"`
+	Next      string `json:"next,omitempty"`
+	// Error is why a batch prefix was rejected, in the same form as a failed
+	// request's
+	Error *apiError `json:"error,omitempty"`
+}
+
+// cidrPrefix validates raw as a prefix that can be broken down
+func (a *app) cidrPrefix(raw string, strict bool) (netip.Prefix, error) {
+	prefix, err := parseCidr(raw, strict)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if hostBits := prefix.Addr().BitLen() - prefix.Bits(); prefix.Addr().Is6() && hostBits > a.cfg.MaxIpv6SpanBits {
+		return netip.Prefix{}, fmt.Errorf("IPv6 prefixes must be /%d or longer", 128-a.cfg.MaxIpv6SpanBits)
+	}
+	return prefix, nil
+}
+
+// cidrInfo validates raw and breaks it down into per-country segments. The
+// breakdown is cut short once ctx is done, and with a positive limit after
+// that many segments, which truncated reports.
+func (a *app) cidrInfo(ctx context.Context, data *dataset, raw string, strict bool, limit int) (res cidrResult, truncated bool, err error) {
+	prefix, err := a.cidrPrefix(raw, strict)
+	if err != nil {
+		return cidrResult{}, false, err
+	}
+	start, end := prefixSpan(prefix)
+	segments, next := a.segments(ctx, data, start, end, prefix.Addr().Is6(), limit)
+	return cidrResult{Ok: true, Cidr: prefix.String(), Segments: segments}, next != nil, nil
+}
+
+// getCidrInfo breaks down the prefix ?cidr=... into per-country segments.
+// ?strict=1 rejects prefixes with host bits set instead of masking them.
+// Past MAX_RESULT_ROWS segments the response is truncated, with a link to
+// the rest, which resumes at the address ?from= inside the prefix.
+func (a *app) getCidrInfo(c *gin.Context) {
+	prefix, err := a.cidrPrefix(c.Query("cidr"), c.Query("strict") == "1")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	v6 := prefix.Addr().Is6()
+	start, end := prefixSpan(prefix)
+	if raw := c.Query("from"); raw != "" {
+		from, err := netip.ParseAddr(raw)
+		if err != nil || !prefix.Contains(from.Unmap()) {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("from must be an address inside %s", prefix))
+			return
+		}
+		start = new(big.Int).SetBytes(from.Unmap().AsSlice())
+	}
+
+	segments, next := a.segments(c.Request.Context(), a.current(), start, end, v6, a.cfg.maxResultRows("getCidrInfo"))
+	if requestDone(c) {
+		respondDone(c)
+		return
+	}
+	res := cidrResult{Ok: true, Cidr: prefix.String(), Segments: segments}
+	if next != nil {
+		res.Truncated = true
+		res.Next = nextPage(c, "from", numToIp(next, v6).String())
+	}
+	renderJSON(c, http.StatusOK, res)
+}
+
+// getCidrInfoBatch breaks down a JSON array of prefixes, one result per
+// prefix in input order. A rejected prefix gets an ok:false result rather
+// than failing the batch. The total number of segments is capped at
+// MAX_CIDR_SEGMENTS: a buffered response fails with 400 past the cap, and a
+// streamed one ends with a too_many_segments line.
+func (a *app) getCidrInfoBatch(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, a.cfg.MaxUploadBytes)
+
+	var prefixes []string
+	if err := json.NewDecoder(c.Request.Body).Decode(&prefixes); err != nil {
+		if requestDone(c) {
+			respondDone(c)
+			return
+		}
+		respondBodyError(c, err, "body must be a JSON array of CIDR prefixes")
+		return
+	}
+	if len(prefixes) > a.cfg.MaxBatch {
+		respondError(c, http.StatusBadRequest, codeTooMany, fmt.Sprintf("at most %d prefixes per request", a.cfg.MaxBatch))
+		return
+	}
+
+	strict := c.Query("strict") == "1"
+	stream := wantsStream(c)
+	tooMany := fmt.Sprintf("at most %d segments per request", a.cfg.MaxCidrSegments)
+	if stream {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+	}
+	enc := json.NewEncoder(c.Writer)
+
+	data := a.current()
+	results := make([]cidrResult, 0, len(prefixes))
+	total := 0
+	for _, raw := range prefixes {
+		// Break the prefix down no further than one segment past what is
+		// left of the cap, so that 0.0.0.0/0 or ::/0 is rejected without
+		// building every segment first
+		res, truncated, err := a.cidrInfo(c.Request.Context(), data, raw, strict, a.cfg.MaxCidrSegments-total+1)
+		if requestDone(c) {
+			respondDone(c)
+			return
+		}
+		if err != nil {
+			res = cidrResult{Cidr: raw, Error: &apiError{codeInvalidRequest, err.Error()}}
+		}
+		if total += len(res.Segments); truncated || total > a.cfg.MaxCidrSegments {
+			if stream {
+				enc.Encode(newErrorResponse(codeTooManySegments, tooMany))
+				return
+			}
+			respondError(c, http.StatusBadRequest, codeTooManySegments, tooMany)
+			return
+		}
+		if stream {
+			enc.Encode(shape(c, res))
+			c.Writer.Flush()
+			continue
+		}
+		results = append(results, res)
+	}
+	if !stream {
+		renderJSON(c, http.StatusOK, results)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRangeToCidrs(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		v6         bool
+		want       string
+	}{
+		{name: "aligned /24", start: "16777216", end: "16777471", want: "1.0.0.0/24"},
+		{name: "single address", start: "16777217", end: "16777217", want: "1.0.0.1/32"},
+		{name: "unaligned", start: "16777217", end: "16777226", want: "1.0.0.1/32 1.0.0.2/31 1.0.0.4/30 1.0.0.8/31 1.0.0.10/32"},
+		{name: "across a /24 boundary", start: "16777456", end: "16777487", want: "1.0.0.240/28 1.0.1.0/28"},
+		{name: "whole ipv4 space", start: "0", end: "4294967295", want: "0.0.0.0/0"},
+		{name: "ipv6 /32", start: "42540766411282592856903984951653826560", end: "42540766490510755371168322545197776895", v6: true, want: "2001:db8::/32"},
+		{name: "ipv6 unaligned", start: "42540766411282592856903984951653826561", end: "42540766411282592856903984951653826563", v6: true, want: "2001:db8::1/128 2001:db8::2/127"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(rangeToCidrs(bigNum(tt.start), bigNum(tt.end), tt.v6), " ")
+			if got != tt.want {
+				t.Errorf("rangeToCidrs(%s, %s) = %s, want %s", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCidr(t *testing.T) {
+	tests := []struct {
+		raw     string
+		strict  bool
+		want    string // canonical prefix, "" when rejected
+		wantErr string // part of the error when rejected
+	}{
+		{raw: "1.0.0.0/24", want: "1.0.0.0/24"},
+		{raw: " 2001:db8::/32 ", want: "2001:db8::/32"},
+		{raw: "0.0.0.0/0", want: "0.0.0.0/0"},
+		{raw: "::/0", want: "::/0"},
+		{raw: "1.0.0.7/24", want: "1.0.0.0/24"},
+		{raw: "1.0.0.7/24", strict: true, wantErr: "host bits set, did you mean 1.0.0.0/24?"},
+		{raw: "2001:db8::1/32", want: "2001:db8::/32"},
+		{raw: "2001:db8::1/32", strict: true, wantErr: "host bits set"},
+		{raw: "::ffff:1.0.0.0/120", want: "1.0.0.0/24"},
+		{raw: "::ffff:1.0.0.0/96", want: "0.0.0.0/0"},
+		{raw: "::ffff:1.0.0.7/120", strict: true, wantErr: "1.0.0.7/24 has host bits set"},
+		{raw: "::ffff:0.0.0.0/95", wantErr: "IPv4-mapped prefixes must be at least /96"},
+		{raw: "fe80::1%eth0/64", wantErr: "can't have a zone"},
+		{raw: "1.0.0.0/33", wantErr: "malformed"},
+		{raw: "::/129", wantErr: "malformed"},
+		{raw: "1.0.0.0/-1", wantErr: "malformed"},
+		{raw: "1.0.0.0", wantErr: "malformed"},
+		{raw: "", wantErr: "malformed"},
+	}
+	for _, tt := range tests {
+		prefix, err := parseCidr(tt.raw, tt.strict)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseCidr(%q, %v) = %s, %v, want error %q", tt.raw, tt.strict, prefix, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || prefix.String() != tt.want {
+			t.Errorf("parseCidr(%q, %v) = %s, %v, want %s", tt.raw, tt.strict, prefix, err, tt.want)
+		}
+	}
+}
+
+func TestCidrPrefixSpanCap(t *testing.T) {
+	a := &app{cfg: &Config{MaxIpv6SpanBits: 64}}
+	tests := []struct {
+		raw string
+		ok  bool
+	}{
+		{raw: "2001:db8::/64", ok: true},
+		{raw: "2001:db8::/63"},
+		{raw: "::/0"},
+		// The cap is only for IPv6, and mapped prefixes are IPv4
+		{raw: "0.0.0.0/0", ok: true},
+		{raw: "::ffff:0.0.0.0/96", ok: true},
+	}
+	for _, tt := range tests {
+		_, err := a.cidrPrefix(tt.raw, false)
+		if (err == nil) != tt.ok {
+			t.Errorf("cidrPrefix(%q) error = %v, want ok %v", tt.raw, err, tt.ok)
+		}
+		if err != nil && !strings.Contains(err.Error(), "must be /64 or longer") {
+			t.Errorf("cidrPrefix(%q) error = %v, want the span cap", tt.raw, err)
+		}
+	}
+}
+
+func TestCidrInfoBudget(t *testing.T) {
+	// 1.0.0.0/16 is 256 /24s, each a segment of its own, but 2.0.0.0/24 is
+	// a single one
+	specs := make([]string, 0, 257)
+	for i := range 256 {
+		start := 16777216 + i*256
+		specs = append(specs, fmt.Sprintf("%d-%d:%s", start, start+255, []string{"AA", "BB"}[i%2]))
+	}
+	specs = append(specs, "33554432-33554687:CC")
+	a := newTestApp(t, specs...)
+	a.cfg.MaxCidrSegments = 10
+
+	res, truncated, err := a.cidrInfo(context.Background(), a.current(), "0.0.0.0/0", false, 4)
+	if err != nil || !truncated || len(res.Segments) != 4 {
+		t.Errorf("cidrInfo(0.0.0.0/0, limit 4) = %d segments, truncated %v, %v, want 4 and truncated", len(res.Segments), truncated, err)
+	}
+	if res, truncated, err := a.cidrInfo(context.Background(), a.current(), "2.0.0.0/24", false, 4); err != nil || truncated || len(res.Segments) != 1 {
+		t.Errorf("cidrInfo(2.0.0.0/24, limit 4) = %d segments, truncated %v, %v, want 1 and complete", len(res.Segments), truncated, err)
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `["2.0.0.0/24", "1.0.0.0/29"]`, status: http.StatusOK},
+		{body: `["2.0.0.0/24", "0.0.0.0/0"]`, status: http.StatusBadRequest},
+		// Exactly the cap, then one segment past it
+		{body: `["1.0.0.0/21", "1.0.8.0/24", "2.0.0.0/24"]`, status: http.StatusOK},
+		{body: `["1.0.0.0/21", "1.0.8.0/23", "2.0.0.0/24"]`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := serveTest(a.getCidrInfoBatch, httptest.NewRequest("POST", "/getCidrInfoBatch", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.body, w.Code, tt.status, w.Body)
+		}
+		if tt.status == http.StatusBadRequest && !strings.Contains(w.Body.String(), codeTooManySegments) {
+			t.Errorf("%s: body %s, want %s", tt.body, w.Body, codeTooManySegments)
+		}
+	}
+}
+
+func TestWalkSegmentsIpv6BelowIpv4Space(t *testing.T) {
+	// IPv6 numbers up to maxIpv4Num would land in the IPv4 range, but
+	// lookups never resolve them, so breakdowns mustn't either
+	arr := testRanges("0-4294967295:US", "4294967296-4294967551:DE")
+	tests := []struct {
+		name       string
+		start, end string
+		limit      int
+		want       string // "start-end:country" segments, "-" for a gap
+		wantNext   string
+	}{
+		{name: "all below", start: "0", end: "4294967295", want: "::-::ffff:ffff:-"},
+		{name: "inside below", start: "1", end: "16", want: "::1-::10:-"},
+		{name: "across", start: "0", end: "4294967807", want: "::-::ffff:ffff:- ::1:0:0-::1:0:ff:DE ::1:0:100-::1:0:1ff:-"},
+		{name: "above", start: "4294967296", end: "4294967551", want: "::1:0:0-::1:0:ff:DE"},
+		{name: "limited to the gap", start: "0", end: "4294967807", limit: 1, want: "::-::ffff:ffff:-", wantNext: "4294967296"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, next := walkSegments(context.Background(), arr, bigNum(tt.start), bigNum(tt.end), true, tt.limit)
+			var got []string
+			for _, seg := range segments {
+				country := "-"
+				if seg.Country != nil {
+					country = *seg.Country
+				}
+				got = append(got, seg.Start+"-"+seg.End+":"+country)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("segments = %v, want %s", got, tt.want)
+			}
+			if gotNext := fmt.Sprint(next); (next != nil || tt.wantNext != "") && gotNext != tt.wantNext {
+				t.Errorf("next = %s, want %s", gotNext, tt.wantNext)
+			}
+		})
+	}
+
+	// The IPv4 walk is unchanged
+	segments, _ := walkSegments(context.Background(), arr, bigNum("0"), bigNum("16"), false, 0)
+	if len(segments) != 1 || segments[0].Country == nil || *segments[0].Country != "US" {
+		t.Errorf("IPv4 segments = %+v, want one US segment", segments)
+	}
+
+	a := newTestApp(t, "0-4294967295:US", "4294967296-4294967551:DE")
+	for _, target := range []string{"/rangeInfo?start=::&end=::ffff:ffff", "/getCidrInfo?cidr=::/96"} {
+		handler := a.rangeInfo
+		if strings.HasPrefix(target, "/getCidrInfo") {
+			handler = a.getCidrInfo
+		}
+		w := serveTest(handler, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"segments":[{"start":"::","end":"::ffff:ffff","country":null}]`) {
+			t.Errorf("%s: status %d, body %s, want a single gap", target, w.Code, w.Body)
+		}
+	}
+}
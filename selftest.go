@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// selftestMaxExamples caps how many violations of each check are listed
+const selftestMaxExamples = 10
+
+var countryCodeRe = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// selftestCheck is the outcome of one invariant over the loaded ranges
+type selftestCheck struct {
+	Name       string   `json:"name"`
+	Ok         bool     `json:"ok"`
+	Violations int      `json:"violations"`
+	Examples   []string `json:"examples,omitempty"`
+}
+
+func (ch *selftestCheck) fail(format string, args ...any) {
+	ch.Violations++
+	if len(ch.Examples) < selftestMaxExamples {
+		ch.Examples = append(ch.Examples, fmt.Sprintf(format, args...))
+	}
+}
+
+// runSelftest checks arr for nil numbers, inverted ranges, sort order,
+// overlaps and malformed country codes. Overlaps are reported but allowed,
+// since lookups resolve them deterministically.
+func runSelftest(arr []IpAddressRange) []selftestCheck {
+	nils := selftestCheck{Name: "no_nil_numbers"}
+	inverted := selftestCheck{Name: "start_not_after_end"}
+	sorted := selftestCheck{Name: "sorted"}
+	overlaps := selftestCheck{Name: "non_overlapping"}
+	countries := selftestCheck{Name: "valid_country_codes"}
+
+	var prev *IpAddressRange
+	var maxEnd *big.Int
+	for i := range arr {
+		r := &arr[i]
+		if r.start == nil || r.end == nil {
+			nils.fail("range %d has a nil start or end", i)
+			continue
+		}
+		if r.start.Cmp(r.end) > 0 {
+			inverted.fail("range %d: start %s is after end %s", i, r.start, r.end)
+		}
+		if !countryCodeRe.MatchString(r.country) {
+			countries.fail("range %d (%s-%s): country %q", i, r.start, r.end, r.country)
+		}
+		if prev != nil {
+			if c := prev.start.Cmp(r.start); c > 0 || (c == 0 && prev.end.Cmp(r.end) > 0) {
+				sorted.fail("range %d (%s-%s) sorts before the previous one (%s-%s)", i, r.start, r.end, prev.start, prev.end)
+			}
+			if r.start.Cmp(maxEnd) <= 0 {
+				overlaps.fail("range %d (%s-%s) overlaps an earlier range ending at %s", i, r.start, r.end, maxEnd)
+			}
+		}
+		if maxEnd == nil || r.end.Cmp(maxEnd) > 0 {
+			maxEnd = r.end
+		}
+		prev = r
+	}
+
+	checks := []selftestCheck{nils, inverted, sorted, overlaps, countries}
+	for i := range checks {
+		checks[i].Ok = checks[i].Violations == 0
+	}
+	return checks
+}
+
+// adminSelftest runs the invariant checks over the data being served.
+// ok is false if any check other than non_overlapping failed.
+func (a *app) adminSelftest(c *gin.Context) {
+	data := a.current()
+	checks := runSelftest(data.arr)
+	ok := true
+	for _, ch := range checks {
+		if !ch.Ok && ch.Name != "non_overlapping" {
+			ok = false
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": ok, "ranges": len(data.arr), "checks": checks})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/netutil"
+)
+
+// basePath normalizes the BASE_PATH prefix routes are mounted under, so that
+// "geo", "/geo" and "/geo/" all become "/geo". Empty means the root.
+func basePath(raw string) string {
+	trimmed := strings.Trim(strings.TrimSpace(raw), "/")
+	if trimmed == "" {
+		return "/"
+	}
+	return "/" + trimmed
+}
+
+// newHTTPServer wraps handler in an http.Server with the configured tuning
+func newHTTPServer(cfg *Config, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           countInFlight(limitConcurrent(cfg.MaxConcurrent, probePaths(cfg), handler)),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	srv.SetKeepAlivesEnabled(cfg.KeepAlive)
+	return srv
+}
+
+// inFlight counts requests currently being handled
+var inFlight atomic.Int64
+
+func countInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// probePaths are the paths of /healthz and /ready, wherever OpsAtRoot and
+// BasePath mount them
+func probePaths(cfg *Config) map[string]bool {
+	ops := cfg.BasePath
+	if cfg.OpsAtRoot {
+		ops = "/"
+	}
+	return map[string]bool{path.Join(ops, "healthz"): true, path.Join(ops, "ready"): true}
+}
+
+// limitConcurrent rejects requests with a 503 while max others are being
+// handled, rather than queueing them. A max of 0 means no limit. Requests
+// for the exempt paths, the health and readiness probes, are never turned
+// away or counted, so an instance busy with lookups isn't taken for a dead
+// one and restarted.
+func limitConcurrent(max int, exempt map[string]bool, next http.Handler) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	sem := make(chan struct{}, max)
+	overloaded, _ := json.Marshal(newErrorResponse(codeOverloaded, "too many concurrent requests, retry later"))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(overloaded)
+		}
+	})
+}
+
+// serve runs srv until it fails or ctx is cancelled, in which case it shuts
+// down gracefully. It serves HTTPS, which negotiates HTTP/2, when a TLS
+// certificate is configured, and caps simultaneous connections when
+// MaxConnections is positive.
+func serve(ctx context.Context, cfg *Config, srv *http.Server) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	if cfg.MaxConnections > 0 {
+		ln = netutil.LimitListener(ln, cfg.MaxConnections)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if cfg.TLSCertFile != "" {
+			errc <- srv.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			errc <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	return shutdown(cfg, srv)
+}
+
+// shutdown stops accepting connections and waits up to ShutdownTimeout for
+// in-flight requests to finish, then force-closes whatever is left
+func shutdown(cfg *Config, srv *http.Server) error {
+	slog.Info("shutting down", "in_flight", inFlight.Load(), "timeout", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("shutdown timed out, closing remaining connections", "in_flight", inFlight.Load())
+		return srv.Close()
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLimitConcurrentExemptsProbes(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		target string
+		want   int
+	}{
+		{name: "lookup", cfg: Config{BasePath: "/"}, target: "/getIpInfo", want: http.StatusServiceUnavailable},
+		{name: "healthz", cfg: Config{BasePath: "/"}, target: "/healthz", want: http.StatusOK},
+		{name: "ready under base path", cfg: Config{BasePath: "/geo"}, target: "/geo/ready", want: http.StatusOK},
+		{name: "ops at root", cfg: Config{BasePath: "/geo", OpsAtRoot: true}, target: "/healthz", want: http.StatusOK},
+		{name: "ops moved to root", cfg: Config{BasePath: "/geo", OpsAtRoot: true}, target: "/geo/healthz", want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			busy, release := make(chan struct{}), make(chan struct{})
+			h := limitConcurrent(1, probePaths(&tt.cfg), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					close(busy)
+					<-release
+				}
+			}))
+			go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+			<-busy
+			defer close(release)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.want {
+				t.Errorf("%s with the limit reached = %d, want %d", tt.target, w.Code, tt.want)
+			}
+		})
+	}
+}
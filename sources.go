@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadedSources lists the sources with at least one loaded file, in file
+// order
+func loadedSources(statuses []fileStatus) []string {
+	sources := []string{}
+	for _, st := range statuses {
+		if st.Loaded && !slices.Contains(sources, st.Source) {
+			sources = append(sources, st.Source)
+		}
+	}
+	return sources
+}
+
+// adminSetSource enables or disables the CSV source named in the path and
+// reloads without it, or with it again, from disk. The change lasts until
+// a reload reads the config again, as SIGHUP does.
+func (a *app) adminSetSource(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("source")
+		if a.current().cfg.DataBackend != "csv" {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, "only the csv backend has sources to toggle")
+			return
+		}
+		if !a.current().cfg.knownSource(name) {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("unknown source %q", name))
+			return
+		}
+
+		// Joining a running reload doesn't apply the change, so wait for it
+		// and try again with the settings it left
+		var result reloadResult
+		for joined := true; joined; {
+			cfg := *a.current().cfg
+			cfg.DisabledSources = maps.Clone(cfg.DisabledSources)
+			if cfg.DisabledSources == nil {
+				cfg.DisabledSources = map[string]bool{}
+			}
+			if enabled {
+				delete(cfg.DisabledSources, name)
+			} else {
+				cfg.DisabledSources[name] = true
+			}
+			if err := cfg.validate(); err != nil {
+				respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+				return
+			}
+			result, joined = a.reload("admin", false, &cfg)
+		}
+		if !result.Ok {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"ok":     false,
+				"error":  apiError{codeReloadFailed, result.Error},
+				"reload": result,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true, "active_sources": loadedSources(a.statuses()), "reload": result})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSource serves ranges from a SQLite table with start_num, end_num
+// and country columns. Numbers may be stored as integers or, since IPv6
+// exceeds int64, as decimal text. The table is read into memory at load
+// time, so lookups behave exactly as with CSVs.
+type sqliteSource struct{}
+
+func (sqliteSource) Update(cfg *Config) (updateResult, error) {
+	return updateResult{}, nil
+}
+
+func (sqliteSource) Load(cfg *Config) ([]IpAddressRange, []fileStatus, error) {
+	fi := &fileInfo{RemotePath: "sqlite/" + cfg.SqliteTable, LocalName: filepath.Base(cfg.SqlitePath)}
+	st := fileStatus{File: fi.LocalName, Source: fi.Source(), Required: true}
+
+	parseStart := time.Now()
+	arr, skipped, err := readSqliteRanges(cfg, fi)
+	logPhase("parse", parseStart)
+	st.Rows, st.Skipped = len(arr), skipped
+	if err == nil && len(arr) == 0 {
+		err = errors.New("no valid rows")
+	}
+	if skipped > 0 {
+		slog.Warn("skipped malformed rows", "file", fi.LocalName, "rows", skipped)
+	}
+	if err != nil {
+		st.Error = err.Error()
+		return nil, []fileStatus{st}, fmt.Errorf("%s: %w", cfg.SqlitePath, err)
+	}
+
+	st.Loaded = true
+	if info, err := os.Stat(cfg.SqlitePath); err == nil {
+		st.Modified = info.ModTime()
+	}
+	sortStart := time.Now()
+	sortRanges(arr)
+	logPhase("sort", sortStart)
+	return arr, []fileStatus{st}, nil
+}
+
+// readSqliteRanges reads the configured table, dropping rows of a disabled
+// address family. Rows ending past the IPv4 space are taken to be IPv6.
+func readSqliteRanges(cfg *Config, fi *fileInfo) ([]IpAddressRange, int, error) {
+	db, err := sql.Open("sqlite", "file:"+cfg.SqlitePath+"?mode=ro")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer db.Close()
+
+	// table is validated as an identifier at config time
+	rows, err := db.Query(fmt.Sprintf(
+		"SELECT CAST(start_num AS TEXT), CAST(end_num AS TEXT), country FROM %q", cfg.SqliteTable))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	arr := []IpAddressRange{}
+	skipped := 0
+	for rows.Next() {
+		var rawStart, rawEnd, country sql.NullString
+		if err := rows.Scan(&rawStart, &rawEnd, &country); err != nil {
+			return nil, skipped, err
+		}
+		start, ok1 := new(big.Int).SetString(rawStart.String, 10)
+		end, ok2 := new(big.Int).SetString(rawEnd.String, 10)
+		if !ok1 || !ok2 || !country.Valid || end.Cmp(start) < 0 {
+			skipped++
+			continue
+		}
+		if !cfg.familyEnabled(end.Cmp(maxIpv4Num) > 0) {
+			continue
+		}
+		arr = append(arr, IpAddressRange{start, end, country.String, fi})
+	}
+	return arr, skipped, rows.Err()
+}
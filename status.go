@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// version is the build version, set with -ldflags "-X main.version=..."
+var version = "dev"
+
+// buildCommit returns the VCS revision the binary was built from, or
+// "unknown" when it was built outside a repository
+func buildCommit() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+type healthResponse struct {
+	Status string        `json:"status"`
+	Ranges int           `json:"ranges"`
+	Files  []fileStatus  `json:"files"`
+	Canary *canaryResult `json:"canary,omitempty"`
+}
+
+// canaryResult is the outcome of the deep health check's known-good lookup
+type canaryResult struct {
+	Ip       string  `json:"ip"`
+	Expected string  `json:"expected"`
+	Got      *string `json:"got"`
+}
+
+// healthz reports "ok" when every data file loaded, "degraded" when some
+// failed, and fails with 503 when there is no data to serve at all, or
+// "starting" while STARTUP_GATE holds the first load back. With
+// ?deep=1 it also looks up the canary IP and fails unless it resolves to
+// the expected country, catching data that loaded but is wrong.
+func (a *app) healthz(c *gin.Context) {
+	data := a.current()
+	if data == nil {
+		c.JSON(http.StatusServiceUnavailable, healthResponse{Status: "starting", Files: []fileStatus{}})
+		return
+	}
+	resp := healthResponse{Status: "ok", Ranges: len(data.arr), Files: data.statuses}
+	for _, st := range data.statuses {
+		if !st.Loaded && !st.Disabled {
+			resp.Status = "degraded"
+		}
+	}
+
+	status := http.StatusOK
+	if c.Query("deep") == "1" {
+		canary, _ := lookup(data.arr, a.cfg.CanaryIp)
+		resp.Canary = &canaryResult{Ip: a.cfg.CanaryIp, Expected: a.cfg.CanaryCountry, Got: canary.Country}
+		if canary.Country == nil || *canary.Country != a.cfg.CanaryCountry {
+			resp.Status = "unhealthy"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	if len(data.arr) == 0 {
+		resp.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, resp)
+}
+
+type versionResponse struct {
+	Version        string       `json:"version"`
+	Commit         string       `json:"commit"`
+	GoVersion      string       `json:"go_version"`
+	DataUpdated    time.Time    `json:"data_updated,omitzero"`
+	DataAgeSeconds int64        `json:"data_age_seconds"`
+	DataStale      bool         `json:"data_stale"`
+	LoadDurationMs int64        `json:"load_duration_ms"`
+	PendingUpdates []string     `json:"pending_updates,omitempty"`
+	LoadedFrom     string       `json:"loaded_from"`
+	ActiveSources  []string     `json:"active_sources"`
+	Families       []string     `json:"families"`
+	Files          []fileStatus `json:"files"`
+
+	// Disagreement compares the sources where they overlap, left out
+	// unless there are several
+	Disagreement *disagreementStats `json:"source_disagreement,omitempty"`
+}
+
+// version reports the build, the data freshness and the load status of
+// each data file
+func (a *app) version(c *gin.Context) {
+	data := a.current()
+	c.JSON(http.StatusOK, versionResponse{
+		Version:        version,
+		Commit:         buildCommit(),
+		GoVersion:      runtime.Version(),
+		DataUpdated:    a.dataUpdated(),
+		DataAgeSeconds: int64(a.dataAge().Seconds()),
+		DataStale:      a.dataStale(),
+		LoadDurationMs: data.loadDuration.Milliseconds(),
+		PendingUpdates: data.pendingUpdates,
+		LoadedFrom:     data.loadedFrom,
+		ActiveSources:  loadedSources(data.statuses),
+		Disagreement:   data.disagreement,
+		Families:       data.cfg.families(),
+		Files:          data.statuses,
+	})
+}
+
+// dataUpdated returns when the least recently refreshed loaded file was
+// last downloaded or confirmed current, or the zero time if none loaded
+func (a *app) dataUpdated() time.Time {
+	var oldest time.Time
+	for _, st := range a.statuses() {
+		if st.Loaded && (oldest.IsZero() || st.Modified.Before(oldest)) {
+			oldest = st.Modified
+		}
+	}
+	return oldest
+}
+
+func (a *app) dataAge() time.Duration {
+	updated := a.dataUpdated()
+	if updated.IsZero() {
+		return 0
+	}
+	return time.Since(updated)
+}
+
+// dataStale reports whether the data is older than MaxDataAge
+func (a *app) dataStale() bool {
+	return a.cfg.MaxDataAge > 0 && a.dataAge() > a.cfg.MaxDataAge
+}
+
+// watchStaleness logs a warning every interval while the data is stale
+func (a *app) watchStaleness(interval time.Duration) {
+	for {
+		if a.dataStale() {
+			slog.Warn("data is older than MAX_DATA_AGE, check that updates are running",
+				"updated", a.dataUpdated(), "age", a.dataAge().Round(time.Second), "max_age", a.cfg.MaxDataAge)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// loadedFile is one data file in the startup summary
+type loadedFile struct {
+	File string `json:"file"`
+	Rows int    `json:"rows"`
+	SHA  string `json:"sha,omitempty"`
+}
+
+// logStartupSummary logs, in one line, what the service came up with: where
+// its data comes from, what loaded, which optional features are on and
+// where it listens
+func (a *app) logStartupSummary() {
+	data := a.current()
+	cfg := a.cfg
+	source := fmt.Sprintf("%s/%s/%s/%s", cfg.GithubRawBase, repoOwner, repoName, branch)
+	switch {
+	case cfg.DataBackend == "sqlite":
+		source = cfg.SqlitePath
+	case cfg.DataBackend == "rir":
+		source = strings.Join(cfg.DelegatedFiles, ",")
+	case cfg.DataArchiveURL != "":
+		source = cfg.DataArchiveURL
+	}
+	loaded := []loadedFile{}
+	for _, st := range data.statuses {
+		if st.Loaded {
+			loaded = append(loaded, loadedFile{st.File, st.Rows, st.SHA})
+		}
+	}
+	slog.Info("startup summary",
+		"version", version,
+		"backend", cfg.DataBackend,
+		"source", source,
+		"files", loaded,
+		"ranges", len(data.arr),
+		"families", cfg.families(),
+		"features", cfg.features(),
+		"listen", cfg.ListenAddr,
+		"tcp_listen", cfg.TCPAddr,
+		"base_path", cfg.BasePath,
+	)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/netutil"
+)
+
+// tcpMaxLine caps a request line; nothing longer can be an address
+const tcpMaxLine = 256
+
+// serveTCP answers the line protocol on addr until ctx is cancelled: each
+// line holding an IP gets its country code back on a line of its own, or
+// an empty line when there is no match. Connections are closed after
+// IdleTimeout without a request.
+func serveTCP(ctx context.Context, cfg *Config, addr string, resolver func() func(string) ApiResponse) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if cfg.MaxConnections > 0 {
+		ln = netutil.LimitListener(ln, cfg.MaxConnections)
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	slog.Info("serving line protocol", "addr", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go serveTCPConn(conn, cfg.IdleTimeout, resolver)
+	}
+}
+
+func serveTCPConn(conn net.Conn, idle time.Duration, resolver func() func(string) ApiResponse) {
+	defer conn.Close()
+	r := bufio.NewReaderSize(conn, tcpMaxLine)
+	w := bufio.NewWriter(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			// EOF, idle timeout, or a line too long to be an address
+			return
+		}
+
+		// Each line is a request of its own, looked up in the data live then
+		country := ""
+		if resp := resolver()(strings.TrimSpace(string(line))); resp.Ok && !resp.Reserved && resp.Country != nil {
+			country = *resp.Country
+		}
+		w.WriteString(country)
+		w.WriteByte('\n')
+		// Answer pipelined requests in one write
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
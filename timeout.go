@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusClientClosedRequest is nginx's status for a client that went away
+// before the response, which no standard status covers
+const statusClientClosedRequest = 499
+
+// requestTimeout gives each request a deadline of timeout, which the batch,
+// CIDR and upload loops check between items. Reading the body is bounded
+// too, so a client trickling its upload can't outlast it.
+func requestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		http.NewResponseController(c.Writer).SetReadDeadline(deadline)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// requestDone reports whether c's request timed out or the client went away
+func requestDone(c *gin.Context) bool {
+	return c.Request.Context().Err() != nil
+}
+
+// respondDone reports why c's request ended early: a 504 when it ran past
+// REQUEST_TIMEOUT, or a 499 when the client went away. A streamed response
+// that has already started ends with the error as its last line instead.
+func respondDone(c *gin.Context) {
+	status, code, message := doneError(c)
+	if c.Writer.Written() {
+		json.NewEncoder(c.Writer).Encode(newErrorResponse(code, message))
+		return
+	}
+	respondError(c, status, code, message)
+}
+
+// doneError returns the status, code and message respondDone reports for
+// c's ended request
+func doneError(c *gin.Context) (status int, code, message string) {
+	if errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, codeTimeout, "request took longer than REQUEST_TIMEOUT"
+	}
+	return statusClientClosedRequest, codeCanceled, "client closed the request"
+}
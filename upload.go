@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultMaxUploadBytes = 64 << 20
+	uploadFlushEvery      = 1000
+)
+
+// uploadBody returns the list of IPs from either a plain body or the "file"
+// part of a multipart upload, without buffering the whole request
+func uploadBody(c *gin.Context) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return c.Request.Body, nil
+	}
+
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New(`missing "file" part`)
+			}
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// ipInfoFileHandler streams back one result per non-empty input line, in
+// input order, as newline-delimited JSON or (with ?format=csv) as CSV.
+// With ?column=N it enrichFiles a CSV or log file instead. resolver is
+// called once per upload, for all its lines to be looked up in the same
+// data.
+func ipInfoFileHandler(resolver func() func(string) ApiResponse, maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resolve := resolver()
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		// Results are streamed while the upload is still being read, which
+		// HTTP/1 servers otherwise cut short at the first flush
+		if err := http.NewResponseController(c.Writer).EnableFullDuplex(); err != nil {
+			slog.Debug("full duplex unavailable, large uploads may be cut short", "err", err)
+		}
+
+		enrich := c.Query("column") != ""
+		var opts enrichFileOptions
+		if enrich {
+			var err error
+			if opts, err = parseEnrichFileOptions(c); err != nil {
+				respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+				return
+			}
+		}
+		body, err := uploadBody(c)
+		if err != nil {
+			respondBodyError(c, err, err.Error())
+			return
+		}
+		if enrich {
+			enrichFile(c, resolve, body, opts)
+			return
+		}
+
+		asCsv := c.Query("format") == "csv"
+		if asCsv {
+			c.Header("Content-Type", "text/csv")
+		} else {
+			c.Header("Content-Type", "application/x-ndjson")
+		}
+		c.Status(http.StatusOK)
+
+		csvOut := csv.NewWriter(c.Writer)
+		jsonOut := json.NewEncoder(c.Writer)
+		scanner := bufio.NewScanner(body)
+		lines := make([]string, 0, uploadFlushEvery)
+		results := make([]ApiResponse, uploadFlushEvery)
+		n := 0
+		more := true
+		for more && !requestDone(c) {
+			lines = lines[:0]
+			for len(lines) < uploadFlushEvery {
+				if more = scanner.Scan(); !more {
+					break
+				}
+				if line := strings.TrimSpace(scanner.Text()); line != "" {
+					lines = append(lines, line)
+				}
+			}
+			resolveChunk(lines, results, resolve)
+			for i, line := range lines {
+				if asCsv {
+					country := ""
+					if results[i].Country != nil {
+						country = *results[i].Country
+					}
+					csvOut.Write([]string{line, country})
+				} else {
+					jsonOut.Encode(shape(c, results[i]))
+				}
+			}
+			n += len(lines)
+			csvOut.Flush()
+			c.Writer.Flush()
+		}
+		// Headers are already sent, so an upload that stopped early is
+		// reported on a last line, for the client not to take the results
+		// for the whole list
+		if more || scanner.Err() != nil {
+			code, message := uploadError(c, scanner.Err())
+			slog.Warn("uploaded IP list cut short", "code", code, "err", message, "processed", n)
+			writeUploadError(c.Writer, !asCsv, code, message)
+		}
+	}
+}
+
+// resolveChunk sets results[i] to the result for addrs[i], resolving them
+// with one worker per CPU so an upload's lookups are bounded and batched
+// rather than one at a time
+func resolveChunk(addrs []string, results []ApiResponse, resolve func(string) ApiResponse) {
+	var next int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), len(addrs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				i := next
+				next++
+				mu.Unlock()
+				if i >= len(addrs) {
+					return
+				}
+				results[i] = resolve(addrs[i])
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// uploadError returns the code and message of an upload that stopped
+// before its end: the request timing out or being canceled, the body
+// running past MAX_UPLOAD_BYTES, or err failing the read otherwise
+func uploadError(c *gin.Context, err error) (code, message string) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case requestDone(c):
+		_, code, message = doneError(c)
+	case errors.As(err, &tooLarge):
+		code, message = codeTooLarge, fmt.Sprintf("upload is larger than %d bytes, later lines were not read", tooLarge.Limit)
+	default:
+		code, message = codeInvalidRequest, fmt.Sprintf("reading upload: %v", err)
+	}
+	return code, message
+}
+
+// writeUploadError ends a streamed upload response that stopped early with
+// a record saying why: an error object in NDJSON, or a "# error:" comment
+// line in CSV and enriched files
+func writeUploadError(w io.Writer, asJSON bool, code, message string) {
+	if asJSON {
+		json.NewEncoder(w).Encode(newErrorResponse(code, message))
+		return
+	}
+	fmt.Fprintf(w, "# error: %s: %s\n", code, message)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/gin-gonic/gin"
+)
+
+type validateResponse struct {
+	Valid  bool   `json:"valid"`
+	Family string `json:"family"`
+	// Mapped is set for IPv4-mapped IPv6 addresses, which lookups treat as
+	// the IPv4 address they embed
+	Mapped bool `json:"mapped,omitempty"`
+}
+
+// validate checks ?addr= with the same parsing lookups use, without looking
+// it up, answering 400 with invalid_ip for anything a lookup would reject
+func (a *app) validate(c *gin.Context) {
+	raw := c.Query("addr")
+	if raw == "" {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "addr is required")
+		return
+	}
+	ipAddr := parseIpAddress(raw)
+	if ipAddr == nil || ipAddr.IpAddr == nil {
+		respondError(c, http.StatusBadRequest, codeInvalidIP, "addr isn't a valid IPv4 or IPv6 address")
+		return
+	}
+	resp := validateResponse{Valid: true, Family: "v4"}
+	if ipAddr.IpV6 {
+		resp.Family = "v6"
+	}
+	if addr, err := netip.ParseAddr(raw); err == nil && addr.Is4In6() {
+		resp.Mapped = true
+	}
+	c.JSON(http.StatusOK, resp)
+}